@@ -3,6 +3,7 @@ module github.com/a13labs/m3uproxy
 go 1.23.4
 
 require (
+	github.com/a13labs/a13core v0.0.1
 	github.com/elnormous/contenttype v1.0.4
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/gorilla/mux v1.8.1
@@ -11,10 +12,10 @@ require (
 	github.com/spf13/cobra v1.8.1
 	github.com/unki2aut/go-xsd-types v0.0.0-20200220223938-30e5405398f8
 	github.com/valyala/fasthttp v1.60.0
+	golang.org/x/text v0.23.0
 )
 
 require (
-	github.com/a13labs/a13core v0.0.1 // indirect
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
@@ -23,5 +24,4 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 )
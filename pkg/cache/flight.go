@@ -0,0 +1,42 @@
+package cache
+
+import "sync"
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Group collapses concurrent calls with the same key into a single call.
+type Group struct {
+	mux   sync.Mutex
+	calls map[string]*call
+}
+
+// Do runs fn once for all concurrent callers with the same key, shared
+// reports whether the result came from another caller's call.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+	g.mux.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mux.Unlock()
+		c.wg.Wait()
+		return c.val, true, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mux.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mux.Lock()
+	delete(g.calls, key)
+	g.mux.Unlock()
+
+	return c.val, false, c.err
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Manifest is a remapped manifest ready to be served.
+type Manifest struct {
+	Data      []byte
+	MediaType string
+}
+
+type manifestEntry struct {
+	manifest Manifest
+	expires  time.Time
+}
+
+// ManifestCache keeps manifests for a short time and collapses concurrent
+// fetches of the same manifest into one.
+type ManifestCache struct {
+	mux    sync.Mutex
+	items  map[string]manifestEntry
+	flight Group
+}
+
+func NewManifestCache() *ManifestCache {
+	return &ManifestCache{
+		items: make(map[string]manifestEntry),
+	}
+}
+
+func (c *ManifestCache) lookup(key string) (Manifest, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	entry, ok := c.items[key]
+	if !ok || time.Now().After(entry.expires) {
+		return Manifest{}, false
+	}
+	return entry.manifest, true
+}
+
+func (c *ManifestCache) store(key string, manifest Manifest, ttl time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.items {
+		if now.After(entry.expires) {
+			delete(c.items, k)
+		}
+	}
+
+	if ttl > 0 {
+		c.items[key] = manifestEntry{
+			manifest: manifest,
+			expires:  now.Add(ttl),
+		}
+	}
+}
+
+// Get returns the cached manifest or calls fetch, which also returns for how
+// long the manifest can be reused.
+func (c *ManifestCache) Get(key string, fetch func() (Manifest, time.Duration, error)) (Manifest, error) {
+	if manifest, ok := c.lookup(key); ok {
+		return manifest, nil
+	}
+
+	val, _, err := c.flight.Do(key, func() (interface{}, error) {
+		manifest, ttl, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, manifest, ttl)
+		return manifest, nil
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	return val.(Manifest), nil
+}
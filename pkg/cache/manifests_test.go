@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManifestCache(t *testing.T) {
+	c := NewManifestCache()
+	var fetches atomic.Int32
+	fetch := func(data string, ttl time.Duration) func() (Manifest, time.Duration, error) {
+		return func() (Manifest, time.Duration, error) {
+			fetches.Add(1)
+			return Manifest{Data: []byte(data), MediaType: "application/vnd.apple.mpegurl"}, ttl, nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		fetch    func() (Manifest, time.Duration, error)
+		sleep    time.Duration
+		expected string
+		fetches  int32
+	}{
+		{"miss", "a", fetch("a1", 20*time.Millisecond), 0, "a1", 1},
+		{"hit", "a", fetch("a2", 20*time.Millisecond), 0, "a1", 1},
+		{"other key", "b", fetch("b1", time.Minute), 0, "b1", 2},
+		{"expired", "a", fetch("a3", time.Minute), 30 * time.Millisecond, "a3", 3},
+		{"no ttl", "c", fetch("c1", 0), 0, "c1", 4},
+		{"no ttl not cached", "c", fetch("c2", 0), 0, "c2", 5},
+	}
+
+	for _, tt := range tests {
+		time.Sleep(tt.sleep)
+		manifest, err := c.Get(tt.key, tt.fetch)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", tt.name, err)
+		}
+		if string(manifest.Data) != tt.expected || manifest.MediaType != "application/vnd.apple.mpegurl" {
+			t.Errorf("%s: Unexpected manifest. Expected: %s, Got: %s", tt.name, tt.expected, manifest.Data)
+		}
+		if got := fetches.Load(); got != tt.fetches {
+			t.Errorf("%s: Unexpected number of fetches. Expected: %d, Got: %d", tt.name, tt.fetches, got)
+		}
+	}
+}
+
+func TestManifestCache_Coalescing(t *testing.T) {
+	c := NewManifestCache()
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	fetch := func() (Manifest, time.Duration, error) {
+		fetches.Add(1)
+		<-release
+		return Manifest{Data: []byte("manifest")}, time.Minute, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if manifest, err := c.Get("a", fetch); err != nil || string(manifest.Data) != "manifest" {
+				t.Errorf("Unexpected manifest: %s %v", manifest.Data, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Unexpected number of fetches. Expected: 1, Got: %d", got)
+	}
+}
+
+func TestManifestCache_Error(t *testing.T) {
+	c := NewManifestCache()
+	errUpstream := errors.New("upstream failed")
+	if _, err := c.Get("a", func() (Manifest, time.Duration, error) { return Manifest{}, time.Minute, errUpstream }); !errors.Is(err, errUpstream) {
+		t.Errorf("Unexpected error. Expected: %v, Got: %v", errUpstream, err)
+	}
+	// Errors aren't cached
+	manifest, err := c.Get("a", func() (Manifest, time.Duration, error) { return Manifest{Data: []byte("manifest")}, time.Minute, nil })
+	if err != nil || string(manifest.Data) != "manifest" {
+		t.Errorf("Unexpected manifest after an error. Got: %s %v", manifest.Data, err)
+	}
+}
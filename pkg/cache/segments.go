@@ -0,0 +1,131 @@
+// Package cache holds the in-memory caches shared by all viewers of a channel.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Segment is a cached media segment.
+type Segment struct {
+	Data      []byte
+	MediaType string
+}
+
+type segmentEntry struct {
+	key     string
+	segment Segment
+	expires time.Time
+}
+
+// SegmentCache is a size bounded LRU cache of media segments keyed by their
+// upstream URL. Concurrent fetches of the same segment are coalesced.
+type SegmentCache struct {
+	mux     sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+	flight  Group
+}
+
+// SegmentCacheStats reports the cache usage.
+type SegmentCacheStats struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"max_size"`
+}
+
+func NewSegmentCache(maxSize int64) *SegmentCache {
+	return &SegmentCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// MaxEntrySize is the largest segment accepted by the cache.
+func (c *SegmentCache) MaxEntrySize() int64 {
+	return c.maxSize / 8
+}
+
+func (c *SegmentCache) lookup(key string) (Segment, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return Segment{}, false
+	}
+	entry := elem.Value.(*segmentEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return Segment{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.segment, true
+}
+
+func (c *SegmentCache) store(key string, segment Segment, ttl time.Duration) {
+	size := int64(len(segment.Data))
+	if ttl <= 0 || size > c.MaxEntrySize() {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+
+	c.items[key] = c.lru.PushFront(&segmentEntry{
+		key:     key,
+		segment: segment,
+		expires: time.Now().Add(ttl),
+	})
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *SegmentCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*segmentEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.segment.Data))
+}
+
+// Get returns the cached segment or calls fetch to retrieve it, concurrent
+// misses for the same key share a single fetch. hit reports whether the
+// segment didn't require a fetch of its own.
+func (c *SegmentCache) Get(key string, ttl time.Duration, fetch func() (Segment, error)) (segment Segment, hit bool, err error) {
+	if segment, ok := c.lookup(key); ok {
+		return segment, true, nil
+	}
+
+	val, shared, err := c.flight.Do(key, func() (interface{}, error) {
+		segment, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, segment, ttl)
+		return segment, nil
+	})
+	if err != nil {
+		return Segment{}, false, err
+	}
+	return val.(Segment), shared, nil
+}
+
+func (c *SegmentCache) Stats() SegmentCacheStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return SegmentCacheStats{
+		Entries: len(c.items),
+		Size:    c.size,
+		MaxSize: c.maxSize,
+	}
+}
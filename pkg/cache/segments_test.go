@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSegmentCache(t *testing.T) {
+	c := NewSegmentCache(800) // entries up to 100 bytes
+	var fetches atomic.Int32
+	fetch := func(size int) func() (Segment, error) {
+		return func() (Segment, error) {
+			fetches.Add(1)
+			return Segment{Data: make([]byte, size), MediaType: "video/mp2t"}, nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		size    int
+		ttl     time.Duration
+		hit     bool
+		fetches int32
+	}{
+		{"miss", "a", 100, time.Minute, false, 1},
+		{"hit", "a", 100, time.Minute, true, 1},
+		{"too large", "b", 101, time.Minute, false, 2},
+		{"too large not cached", "b", 101, time.Minute, false, 3},
+		{"no ttl", "c", 10, 0, false, 4},
+		{"no ttl not cached", "c", 10, 0, false, 5},
+	}
+
+	for _, tt := range tests {
+		segment, hit, err := c.Get(tt.key, tt.ttl, fetch(tt.size))
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", tt.name, err)
+		}
+		if hit != tt.hit || len(segment.Data) != tt.size || segment.MediaType != "video/mp2t" {
+			t.Errorf("%s: Unexpected segment. Expected: %v %d, Got: %v %d", tt.name, tt.hit, tt.size, hit, len(segment.Data))
+		}
+		if got := fetches.Load(); got != tt.fetches {
+			t.Errorf("%s: Unexpected number of fetches. Expected: %d, Got: %d", tt.name, tt.fetches, got)
+		}
+	}
+}
+
+func TestSegmentCache_Expiry(t *testing.T) {
+	c := NewSegmentCache(800)
+	c.Get("a", 10*time.Millisecond, func() (Segment, error) { return Segment{Data: []byte("old")}, nil })
+	time.Sleep(20 * time.Millisecond)
+
+	segment, hit, _ := c.Get("a", time.Minute, func() (Segment, error) { return Segment{Data: []byte("new")}, nil })
+	if hit || string(segment.Data) != "new" {
+		t.Errorf("Unexpected expired segment. Got: %v %s", hit, segment.Data)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Size != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSegmentCache_Eviction(t *testing.T) {
+	c := NewSegmentCache(800)
+	for i := range 8 {
+		c.Get(strconv.Itoa(i), time.Minute, func() (Segment, error) { return Segment{Data: make([]byte, 100)}, nil })
+	}
+	// The least recently used is evicted, 0 was just used
+	c.Get("0", time.Minute, nil)
+	c.Get("8", time.Minute, func() (Segment, error) { return Segment{Data: make([]byte, 100)}, nil })
+
+	if stats := c.Stats(); stats.Entries != 8 || stats.Size != 800 || stats.MaxSize != 800 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	for _, key := range []string{"0", "2", "8"} {
+		if _, ok := c.lookup(key); !ok {
+			t.Errorf("Unexpected eviction of %s", key)
+		}
+	}
+	if _, ok := c.lookup("1"); ok {
+		t.Errorf("Unexpected segment 1, the least recently used")
+	}
+}
+
+func TestSegmentCache_Coalescing(t *testing.T) {
+	c := NewSegmentCache(800)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	fetch := func() (Segment, error) {
+		fetches.Add(1)
+		<-release
+		return Segment{Data: []byte("segment")}, nil
+	}
+
+	const viewers = 10
+	var hits atomic.Int32
+	var wg sync.WaitGroup
+	for range viewers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segment, hit, err := c.Get("a", time.Minute, fetch)
+			if err != nil || string(segment.Data) != "segment" {
+				t.Errorf("Unexpected segment: %s %v", segment.Data, err)
+			}
+			if hit {
+				hits.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Unexpected number of fetches. Expected: 1, Got: %d", got)
+	}
+	if got := hits.Load(); got != viewers-1 {
+		t.Errorf("Unexpected number of hits. Expected: %d, Got: %d", viewers-1, got)
+	}
+}
+
+func TestSegmentCache_Error(t *testing.T) {
+	c := NewSegmentCache(800)
+	errUpstream := errors.New("upstream failed")
+	if _, _, err := c.Get("a", time.Minute, func() (Segment, error) { return Segment{}, errUpstream }); !errors.Is(err, errUpstream) {
+		t.Errorf("Unexpected error. Expected: %v, Got: %v", errUpstream, err)
+	}
+	// Errors aren't cached
+	segment, hit, err := c.Get("a", time.Minute, func() (Segment, error) { return Segment{Data: []byte("segment")}, nil })
+	if err != nil || hit || string(segment.Data) != "segment" {
+		t.Errorf("Unexpected segment after an error. Got: %s %v %v", segment.Data, hit, err)
+	}
+}
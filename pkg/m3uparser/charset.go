@@ -0,0 +1,56 @@
+package m3uparser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// CharsetFromContentType returns the charset parameter of a Content-Type
+// header, empty if none.
+func CharsetFromContentType(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
+
+// NewUTF8Reader returns a reader converting r to UTF-8. A byte order mark
+// takes precedence over charset, r is assumed UTF-8 if charset is empty.
+func NewUTF8Reader(r io.Reader, charset string) (io.Reader, error) {
+	buf := bufio.NewReader(r)
+	bom, _ := buf.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		buf.Discard(3)
+		return buf, nil
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		return transform.NewReader(buf, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()), nil
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		return transform.NewReader(buf, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()), nil
+	}
+
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "utf8" {
+		return buf, nil
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return transform.NewReader(buf, encoding.NewDecoder()), nil
+}
@@ -0,0 +1,67 @@
+package m3uparser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeWithBOM(t *testing.T) {
+	content := "\xEF\xBB\xBF#EXTM3U\n#EXTINF:-1 tvg-id=\"Café\",Café\nhttp://example.com/channel1.m3u8\n"
+
+	playlist, err := DecodeFromReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse M3U file: %v", err)
+	}
+
+	if len(playlist.Entries) != 1 || playlist.Entries[0].Title != "Café" {
+		t.Errorf("Unexpected entries. Got: %v", playlist.Entries)
+	}
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	content := "#EXTM3U\n#EXTINF:-1,Café\nhttp://example.com/channel1.m3u8\n"
+
+	// UTF-16LE with a byte order mark
+	encoded := []byte{0xFF, 0xFE}
+	for _, r := range content {
+		encoded = append(encoded, byte(r), byte(r>>8))
+	}
+
+	playlist, err := DecodeFromReader(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("Failed to parse M3U file: %v", err)
+	}
+
+	if len(playlist.Entries) != 1 || playlist.Entries[0].Title != "Café" {
+		t.Errorf("Unexpected entries. Got: %v", playlist.Entries)
+	}
+}
+
+func TestNewUTF8Reader(t *testing.T) {
+	// "Café" in Latin-1
+	content := "#EXTM3U\n#EXTINF:-1 tvg-name=\"Caf\xE9\",Caf\xE9\nhttp://example.com/channel1.m3u8\n"
+
+	charset := CharsetFromContentType("audio/x-mpegurl; charset=ISO-8859-1")
+	if charset != "ISO-8859-1" {
+		t.Errorf("Unexpected charset. Expected: ISO-8859-1, Got: %s", charset)
+	}
+
+	reader, err := NewUTF8Reader(strings.NewReader(content), charset)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+
+	playlist, err := DecodeFromReader(reader)
+	if err != nil {
+		t.Fatalf("Failed to parse M3U file: %v", err)
+	}
+
+	if playlist.Entries[0].Title != "Café" || playlist.Entries[0].ExtInfTags.GetValue("tvg-name") != "Café" {
+		t.Errorf("Unexpected entry. Got: %v", playlist.Entries[0])
+	}
+
+	if _, err := NewUTF8Reader(strings.NewReader(content), "unknown-charset"); err == nil {
+		t.Error("Error should not be nil")
+	}
+}
@@ -3,6 +3,8 @@ package m3uparser
 import (
 	"errors"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -20,29 +22,87 @@ type M3UEntry struct {
 	Title      string        `json:"title"`       // The title of the media (if available).
 	Tags       M3UTags       `json:"tags"`        // Additional tags associated with the entry.
 	ExtInfTags M3UExtinfTags `json:"extinf_tags"` // Additional tags associated with the entry.
+
+	raw    string // lossless mode: the input lines of the entry
+	rawKey string // lossless mode: String() when decoded
 }
 
 type M3UEntries []M3UEntry
 
+// String returns the tag line, comments have no tag name.
+func (tag M3UTag) String() string {
+	switch {
+	case tag.Tag == "":
+		return "#" + tag.Value
+	case tag.Value == "":
+		return "#" + tag.Tag
+	}
+	return "#" + tag.Tag + ":" + tag.Value
+}
+
+// extinf returns the EXTINF value for the entry's Duration, ExtInfTags and
+// Title, the decoded value is kept if they didn't change. Duration is rounded,
+// so the decoded duration is kept as written, fractional durations included,
+// while it matches.
+func (entry *M3UEntry) extinf(value string) string {
+	decoded, _ := ParseExtinf(value)
+	sameDuration := int(decoded.Duration) == entry.Duration
+	if sameDuration && decoded.Title == entry.Title && slices.Equal(decoded.Attrs, entry.ExtInfTags) {
+		return value
+	}
+
+	result := strconv.Itoa(entry.Duration)
+	if token := durationToken(value); sameDuration && token != "" {
+		if _, err := strconv.ParseFloat(token, 64); err == nil {
+			result = token
+		}
+	}
+	for _, attr := range entry.ExtInfTags {
+		result += " " + attr.String()
+	}
+	return result + "," + entry.Title
+}
+
+// durationToken returns the duration of an EXTINF value as written.
+func durationToken(value string) string {
+	value = strings.TrimLeft(value, " \t")
+	if end := strings.IndexAny(value, " \t,"); end >= 0 {
+		return value[:end]
+	}
+	return value
+}
+
 func (entry *M3UEntry) String() string {
 	var result string
 	for _, tag := range entry.Tags {
-		result += "#" + tag.Tag + ":" + tag.Value + "\n"
+		if tag.Tag == "EXTINF" {
+			tag.Value = entry.extinf(tag.Value)
+		}
+		result += tag.String() + "\n"
 	}
 	result += entry.URI + "\n"
 	return strings.Trim(result, "\n")
 }
 
-func (entry *M3UEntry) WriteTo(w io.Writer) (int64, error) {
+// encode returns the input lines of an entry decoded in lossless mode and not
+// edited since, the entry's lines otherwise.
+func (entry *M3UEntry) encode() string {
+	if entry.raw != "" {
+		if encoded := entry.String(); encoded != entry.rawKey {
+			return encoded + "\n"
+		}
+		return entry.raw
+	}
+	return entry.String() + "\n"
+}
 
-	n := 0
-	for _, tag := range entry.Tags {
-		nBytes, _ := w.Write([]byte("#" + tag.Tag + ":" + tag.Value + "\n"))
-		n += nBytes
+func (entry *M3UEntry) WriteTo(w io.Writer) (int64, error) {
+	encoded := entry.encode()
+	if !strings.HasSuffix(encoded, "\n") {
+		encoded += "\n"
 	}
-	nBytes, _ := w.Write([]byte(entry.URI + "\n"))
-	n += nBytes
-	return int64(n), nil
+	n, err := io.WriteString(w, encoded)
+	return int64(n), err
 }
 
 // parseTag parses a line that starts with '#' and extracts the tag name and value.
@@ -120,6 +180,23 @@ func (entry *M3UEntry) RemoveTags(tag string) {
 	}
 }
 
+// SetAttr sets an EXTINF attribute, new attributes are added last.
+func (entry *M3UEntry) SetAttr(key string, value string) {
+	for i, attr := range entry.ExtInfTags {
+		if attr.Tag == key {
+			entry.ExtInfTags[i].Value = value
+			return
+		}
+	}
+	entry.ExtInfTags = append(entry.ExtInfTags, M3UTvgTag{Tag: key, Value: value})
+}
+
+func (entry *M3UEntry) DeleteAttr(key string) {
+	entry.ExtInfTags = slices.DeleteFunc(entry.ExtInfTags, func(attr M3UTvgTag) bool {
+		return attr.Tag == key
+	})
+}
+
 func (entry *M3UEntry) AddTag(tag string, value string) {
 	entry.Tags = append(entry.Tags, M3UTag{tag, value})
 }
@@ -1,6 +1,7 @@
 package m3uparser
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -25,3 +26,75 @@ func TestParseTag_EmptyLine(t *testing.T) {
 		t.Error("Error should not be nil")
 	}
 }
+
+func TestEntryStringRebuildsExtinf(t *testing.T) {
+	entry := M3UEntry{
+		URI:        "http://example.com/channel1.m3u8",
+		Duration:   -1,
+		Title:      "Channel 1",
+		Tags:       []M3UTag{{"EXTINF", "-1 tvg-id=\"Channel 1\",Channel 1"}, {"EXTVLCOPT", "http-user-agent=Firefox"}},
+		ExtInfTags: M3UExtinfTags{{"tvg-id", "Channel 1"}},
+	}
+
+	// Unchanged entries keep their EXTINF
+	expected := "#EXTINF:-1 tvg-id=\"Channel 1\",Channel 1\n#EXTVLCOPT:http-user-agent=Firefox\nhttp://example.com/channel1.m3u8"
+	if entry.String() != expected {
+		t.Errorf("Unexpected entry. Expected: %s, Got: %s", expected, entry.String())
+	}
+
+	entry.Title = "Renamed"
+	entry.SetAttr("tvg-id", "Channel \"1\"")
+	entry.SetAttr("group-title", "TV")
+	entry.SetAttr("tvg-logo", "logo.png")
+	entry.DeleteAttr("tvg-logo")
+
+	expected = "#EXTINF:-1 tvg-id=\"Channel \\\"1\\\"\" group-title=\"TV\",Renamed\n#EXTVLCOPT:http-user-agent=Firefox\nhttp://example.com/channel1.m3u8"
+	if entry.String() != expected {
+		t.Errorf("Unexpected entry. Expected: %s, Got: %s", expected, entry.String())
+	}
+
+	// The rebuilt EXTINF decodes to the same values
+	rebuilt, _ := ParseExtinf(entry.extinf(entry.Tags[0].Value))
+	if rebuilt.Title != "Renamed" || rebuilt.Attrs.GetValue("tvg-id") != "Channel \"1\"" {
+		t.Errorf("Unexpected rebuilt EXTINF. Got: %v", rebuilt)
+	}
+}
+
+func TestEntryStringKeepsDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		duration int
+		expected string
+	}{
+		{"10.5 tvg-id=\"1\",Channel 1", 10, "#EXTINF:10.5 tvg-id=\"2\",Channel 1"},
+		{"-1.0 tvg-id=\"1\",Channel 1", -1, "#EXTINF:-1.0 tvg-id=\"2\",Channel 1"},
+		{" 0.000 tvg-id=\"1\",Channel 1", 0, "#EXTINF:0.000 tvg-id=\"2\",Channel 1"},
+		{"-1,Channel 1", -1, "#EXTINF:-1 tvg-id=\"2\",Channel 1"},
+		// Changed or invalid durations are written from Duration
+		{"10.5 tvg-id=\"1\",Channel 1", 12, "#EXTINF:12 tvg-id=\"2\",Channel 1"},
+		{"abc tvg-id=\"1\",Channel 1", -1, "#EXTINF:-1 tvg-id=\"2\",Channel 1"},
+	}
+
+	for _, tt := range tests {
+		playlist, err := DecodeFromReader(strings.NewReader("#EXTM3U\n#EXTINF:" + tt.value + "\nhttp://example.com/channel1.m3u8\n"))
+		if err != nil || len(playlist.Entries) != 1 {
+			t.Fatalf("Unexpected error decoding %s: %v", tt.value, err)
+		}
+		entry := playlist.Entries[0]
+		decoded, _ := ParseExtinf(tt.value)
+		entry.Duration = tt.duration
+		entry.SetAttr("tvg-id", "2")
+
+		expected := tt.expected + "\nhttp://example.com/channel1.m3u8"
+		if entry.String() != expected {
+			t.Errorf("Unexpected entry. Expected: %s, Got: %s", expected, entry.String())
+		}
+
+		// Removing the attribute again gives back an equivalent EXTINF
+		entry.DeleteAttr("tvg-id")
+		rebuilt, _ := ParseExtinf(entry.extinf(tt.value))
+		if int(rebuilt.Duration) != tt.duration || rebuilt.Title != decoded.Title || len(rebuilt.Attrs) != 0 {
+			t.Errorf("Unexpected rebuilt EXTINF of %s. Got: %v", tt.value, rebuilt)
+		}
+	}
+}
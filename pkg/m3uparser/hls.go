@@ -0,0 +1,1015 @@
+package m3uparser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HLSPlaylist is either a *MasterPlaylist or a *MediaPlaylist.
+type HLSPlaylist interface {
+	WriteTo(w io.Writer) (int64, error)
+	String() string
+}
+
+// HLSAttribute is an attribute of an HLS attribute list, Quoted tells if the
+// value is a quoted-string.
+type HLSAttribute struct {
+	Key    string
+	Value  string
+	Quoted bool
+}
+
+type HLSAttributes []HLSAttribute
+
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+type ByteRange struct {
+	Length int64
+	Offset int64 // -1 if the range follows the previous one
+}
+
+// Key is an EXT-X-KEY or EXT-X-SESSION-KEY.
+type Key struct {
+	Method            string
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// Map is an EXT-X-MAP, the media initialization section of a segment.
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// Variant is an EXT-X-STREAM-INF or, if IFrame is set, an
+// EXT-X-I-FRAME-STREAM-INF.
+type Variant struct {
+	URI              string
+	IFrame           bool
+	Bandwidth        int64
+	AverageBandwidth int64
+	Codecs           []string
+	Resolution       *Resolution
+	FrameRate        float64
+	HDCPLevel        string
+	Audio            string
+	Video            string
+	Subtitles        string
+	ClosedCaptions   string
+	Extra            HLSAttributes // attributes without a field
+}
+
+// Rendition is an EXT-X-MEDIA.
+type Rendition struct {
+	Type            string
+	GroupID         string
+	Name            string
+	Language        string
+	AssocLanguage   string
+	URI             string
+	Default         bool
+	Autoselect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+	Extra           HLSAttributes // attributes without a field
+}
+
+// PartialSegment is an EXT-X-PART of a low latency playlist.
+type PartialSegment struct {
+	URI         string
+	Duration    float64
+	Independent bool
+	ByteRange   *ByteRange
+	Gap         bool
+}
+
+// ServerControl is the EXT-X-SERVER-CONTROL of a low latency playlist.
+type ServerControl struct {
+	CanSkipUntil      float64
+	CanSkipDateRanges bool
+	HoldBack          float64
+	PartHoldBack      float64
+	CanBlockReload    bool
+}
+
+// PreloadHint is an EXT-X-PRELOAD-HINT, a resource needed soon.
+type PreloadHint struct {
+	Type      string
+	URI       string
+	ByteRange *ByteRange // offset and optional length, -1 if unknown
+}
+
+// RenditionReport is an EXT-X-RENDITION-REPORT, the last segment and part
+// of another rendition.
+type RenditionReport struct {
+	URI      string
+	LastMSN  int64
+	LastPart int64 // -1 if none
+}
+
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+	Renditions          []*Rendition
+	Variants            []*Variant
+	SessionKeys         []Key
+	Tags                M3UTags // other playlist tags, in order
+}
+
+// MediaSegment is a segment of a media playlist, Keys and Map are the ones in
+// effect for the segment.
+type MediaSegment struct {
+	URI             string
+	Duration        float64
+	Title           string
+	ByteRange       *ByteRange
+	Keys            []Key
+	Map             *Map
+	ProgramDateTime time.Time
+	Discontinuity   bool
+	Gap             bool
+	Parts           []*PartialSegment
+	Tags            M3UTags // other tags preceding the segment, in order
+}
+
+type MediaPlaylist struct {
+	Version               int
+	TargetDuration        int
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string
+	IndependentSegments   bool
+	IFramesOnly           bool
+	EndList               bool
+	ServerControl         *ServerControl
+	PartTarget            float64 // EXT-X-PART-INF, 0 if not a low latency playlist
+	SkippedSegments       int64   // EXT-X-SKIP of a playlist delta update
+	Segments              []*MediaSegment
+	Parts                 []*PartialSegment // parts of the segment in progress
+	PreloadHints          []PreloadHint
+	RenditionReports      []RenditionReport
+	Tags                  M3UTags // other header tags, in order
+	TrailingTags          M3UTags // tags following the last segment
+}
+
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+var masterDirectives = map[string]struct{}{
+	"EXT-X-STREAM-INF":         {},
+	"EXT-X-I-FRAME-STREAM-INF": {},
+	"EXT-X-MEDIA":              {},
+	"EXT-X-SESSION-DATA":       {},
+	"EXT-X-SESSION-KEY":        {},
+}
+
+// ParseHLSAttributes parses an attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+func ParseHLSAttributes(data string) HLSAttributes {
+	var attrs HLSAttributes
+	for {
+		data = strings.TrimLeft(data, " ,")
+		eq := strings.IndexByte(data, '=')
+		if eq < 0 {
+			return attrs
+		}
+
+		attr := HLSAttribute{Key: strings.TrimSpace(data[:eq])}
+		data = data[eq+1:]
+		if strings.HasPrefix(data, "\"") {
+			attr.Quoted = true
+			end := strings.IndexByte(data[1:], '"')
+			if end < 0 {
+				attr.Value = data[1:]
+				data = ""
+			} else {
+				attr.Value = data[1 : end+1]
+				data = data[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(data, ',')
+			if end < 0 {
+				end = len(data)
+			}
+			attr.Value = strings.TrimSpace(data[:end])
+			data = data[end:]
+		}
+		attrs = append(attrs, attr)
+	}
+}
+
+func (attrs HLSAttributes) Get(key string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (attrs HLSAttributes) GetValue(key string) string {
+	value, _ := attrs.Get(key)
+	return value
+}
+
+func (attrs HLSAttributes) String() string {
+	var result strings.Builder
+	for i, attr := range attrs {
+		if i > 0 {
+			result.WriteByte(',')
+		}
+		result.WriteString(attr.Key + "=")
+		if attr.Quoted {
+			result.WriteString("\"" + attr.Value + "\"")
+		} else {
+			result.WriteString(attr.Value)
+		}
+	}
+	return result.String()
+}
+
+// Set replaces the value of an attribute keeping its quoting, or appends it
+// unquoted.
+func (attrs *HLSAttributes) Set(key string, value string) {
+	for i := range *attrs {
+		if (*attrs)[i].Key == key {
+			(*attrs)[i].Value = value
+			return
+		}
+	}
+	attrs.add(key, value, false)
+}
+
+func (attrs *HLSAttributes) add(key string, value string, quoted bool) {
+	*attrs = append(*attrs, HLSAttribute{Key: key, Value: value, Quoted: quoted})
+}
+
+func parseResolution(value string) *Resolution {
+	width, height, found := strings.Cut(value, "x")
+	if !found {
+		return nil
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil {
+		return nil
+	}
+	h, err := strconv.Atoi(height)
+	if err != nil {
+		return nil
+	}
+	return &Resolution{Width: w, Height: h}
+}
+
+func (r Resolution) String() string {
+	return fmt.Sprintf("%dx%d", r.Width, r.Height)
+}
+
+// ParseByteRange parses a byte range in the <length>[@<offset>] format.
+func ParseByteRange(value string) (*ByteRange, error) {
+	length, offset, found := strings.Cut(strings.TrimSpace(value), "@")
+	byteRange := &ByteRange{Offset: -1}
+	var err error
+	if byteRange.Length, err = strconv.ParseInt(length, 10, 64); err != nil {
+		return nil, err
+	}
+	if found {
+		if byteRange.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return byteRange, nil
+}
+
+func (b ByteRange) String() string {
+	if b.Offset < 0 {
+		return strconv.FormatInt(b.Length, 10)
+	}
+	return fmt.Sprintf("%d@%d", b.Length, b.Offset)
+}
+
+func parseKey(value string) Key {
+	attrs := ParseHLSAttributes(value)
+	return Key{
+		Method:            attrs.GetValue("METHOD"),
+		URI:               attrs.GetValue("URI"),
+		IV:                attrs.GetValue("IV"),
+		KeyFormat:         attrs.GetValue("KEYFORMAT"),
+		KeyFormatVersions: attrs.GetValue("KEYFORMATVERSIONS"),
+	}
+}
+
+func (k Key) String() string {
+	attrs := HLSAttributes{{Key: "METHOD", Value: k.Method}}
+	if k.URI != "" {
+		attrs.add("URI", k.URI, true)
+	}
+	if k.IV != "" {
+		attrs.add("IV", k.IV, false)
+	}
+	if k.KeyFormat != "" {
+		attrs.add("KEYFORMAT", k.KeyFormat, true)
+	}
+	if k.KeyFormatVersions != "" {
+		attrs.add("KEYFORMATVERSIONS", k.KeyFormatVersions, true)
+	}
+	return attrs.String()
+}
+
+func parseMap(value string) (*Map, error) {
+	attrs := ParseHLSAttributes(value)
+	m := &Map{URI: attrs.GetValue("URI")}
+	if byteRange, ok := attrs.Get("BYTERANGE"); ok {
+		var err error
+		if m.ByteRange, err = ParseByteRange(byteRange); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Map) equal(other *Map) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	if m.URI != other.URI || (m.ByteRange == nil) != (other.ByteRange == nil) {
+		return false
+	}
+	return m.ByteRange == nil || *m.ByteRange == *other.ByteRange
+}
+
+func (m *Map) String() string {
+	attrs := HLSAttributes{{Key: "URI", Value: m.URI, Quoted: true}}
+	if m.ByteRange != nil {
+		attrs.add("BYTERANGE", m.ByteRange.String(), true)
+	}
+	return attrs.String()
+}
+
+func parseVariant(value string, iframe bool) *Variant {
+	variant := &Variant{IFrame: iframe}
+	for _, attr := range ParseHLSAttributes(value) {
+		switch attr.Key {
+		case "BANDWIDTH":
+			variant.Bandwidth, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "AVERAGE-BANDWIDTH":
+			variant.AverageBandwidth, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "CODECS":
+			for _, codec := range strings.Split(attr.Value, ",") {
+				if codec = strings.TrimSpace(codec); codec != "" {
+					variant.Codecs = append(variant.Codecs, codec)
+				}
+			}
+		case "RESOLUTION":
+			variant.Resolution = parseResolution(attr.Value)
+		case "FRAME-RATE":
+			variant.FrameRate, _ = strconv.ParseFloat(attr.Value, 64)
+		case "HDCP-LEVEL":
+			variant.HDCPLevel = attr.Value
+		case "AUDIO":
+			variant.Audio = attr.Value
+		case "VIDEO":
+			variant.Video = attr.Value
+		case "SUBTITLES":
+			variant.Subtitles = attr.Value
+		case "CLOSED-CAPTIONS":
+			variant.ClosedCaptions = attr.Value
+		case "URI":
+			if iframe {
+				variant.URI = attr.Value
+				continue
+			}
+			variant.Extra = append(variant.Extra, attr)
+		default:
+			variant.Extra = append(variant.Extra, attr)
+		}
+	}
+	return variant
+}
+
+func (v *Variant) attributes() HLSAttributes {
+	attrs := HLSAttributes{{Key: "BANDWIDTH", Value: strconv.FormatInt(v.Bandwidth, 10)}}
+	if v.AverageBandwidth > 0 {
+		attrs.add("AVERAGE-BANDWIDTH", strconv.FormatInt(v.AverageBandwidth, 10), false)
+	}
+	if len(v.Codecs) > 0 {
+		attrs.add("CODECS", strings.Join(v.Codecs, ","), true)
+	}
+	if v.Resolution != nil {
+		attrs.add("RESOLUTION", v.Resolution.String(), false)
+	}
+	if v.FrameRate > 0 {
+		attrs.add("FRAME-RATE", strconv.FormatFloat(v.FrameRate, 'f', 3, 64), false)
+	}
+	if v.HDCPLevel != "" {
+		attrs.add("HDCP-LEVEL", v.HDCPLevel, false)
+	}
+	if v.Audio != "" {
+		attrs.add("AUDIO", v.Audio, true)
+	}
+	if v.Video != "" {
+		attrs.add("VIDEO", v.Video, true)
+	}
+	if v.Subtitles != "" {
+		attrs.add("SUBTITLES", v.Subtitles, true)
+	}
+	if v.ClosedCaptions != "" {
+		attrs.add("CLOSED-CAPTIONS", v.ClosedCaptions, v.ClosedCaptions != "NONE")
+	}
+	if v.IFrame {
+		attrs.add("URI", v.URI, true)
+	}
+	return append(attrs, v.Extra...)
+}
+
+func (v *Variant) String() string {
+	if v.IFrame {
+		return "#EXT-X-I-FRAME-STREAM-INF:" + v.attributes().String()
+	}
+	return "#EXT-X-STREAM-INF:" + v.attributes().String() + "\n" + v.URI
+}
+
+func parseRendition(value string) *Rendition {
+	rendition := &Rendition{}
+	for _, attr := range ParseHLSAttributes(value) {
+		switch attr.Key {
+		case "TYPE":
+			rendition.Type = attr.Value
+		case "GROUP-ID":
+			rendition.GroupID = attr.Value
+		case "NAME":
+			rendition.Name = attr.Value
+		case "LANGUAGE":
+			rendition.Language = attr.Value
+		case "ASSOC-LANGUAGE":
+			rendition.AssocLanguage = attr.Value
+		case "URI":
+			rendition.URI = attr.Value
+		case "DEFAULT":
+			rendition.Default = attr.Value == "YES"
+		case "AUTOSELECT":
+			rendition.Autoselect = attr.Value == "YES"
+		case "FORCED":
+			rendition.Forced = attr.Value == "YES"
+		case "INSTREAM-ID":
+			rendition.InstreamID = attr.Value
+		case "CHARACTERISTICS":
+			rendition.Characteristics = attr.Value
+		case "CHANNELS":
+			rendition.Channels = attr.Value
+		default:
+			rendition.Extra = append(rendition.Extra, attr)
+		}
+	}
+	return rendition
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "YES"
+	}
+	return "NO"
+}
+
+func (r *Rendition) String() string {
+	attrs := HLSAttributes{
+		{Key: "TYPE", Value: r.Type},
+		{Key: "GROUP-ID", Value: r.GroupID, Quoted: true},
+	}
+	if r.Language != "" {
+		attrs.add("LANGUAGE", r.Language, true)
+	}
+	if r.AssocLanguage != "" {
+		attrs.add("ASSOC-LANGUAGE", r.AssocLanguage, true)
+	}
+	attrs.add("NAME", r.Name, true)
+	attrs.add("DEFAULT", yesNo(r.Default), false)
+	attrs.add("AUTOSELECT", yesNo(r.Autoselect), false)
+	if r.Forced {
+		attrs.add("FORCED", "YES", false)
+	}
+	if r.InstreamID != "" {
+		attrs.add("INSTREAM-ID", r.InstreamID, true)
+	}
+	if r.Characteristics != "" {
+		attrs.add("CHARACTERISTICS", r.Characteristics, true)
+	}
+	if r.Channels != "" {
+		attrs.add("CHANNELS", r.Channels, true)
+	}
+	if r.URI != "" {
+		attrs.add("URI", r.URI, true)
+	}
+	return "#EXT-X-MEDIA:" + append(attrs, r.Extra...).String()
+}
+
+func parsePart(value string) (*PartialSegment, error) {
+	part := &PartialSegment{}
+	for _, attr := range ParseHLSAttributes(value) {
+		var err error
+		switch attr.Key {
+		case "URI":
+			part.URI = attr.Value
+		case "DURATION":
+			part.Duration, err = strconv.ParseFloat(attr.Value, 64)
+		case "INDEPENDENT":
+			part.Independent = attr.Value == "YES"
+		case "BYTERANGE":
+			part.ByteRange, err = ParseByteRange(attr.Value)
+		case "GAP":
+			part.Gap = attr.Value == "YES"
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return part, nil
+}
+
+func (p *PartialSegment) String() string {
+	attrs := HLSAttributes{
+		{Key: "DURATION", Value: strconv.FormatFloat(p.Duration, 'f', -1, 64)},
+		{Key: "URI", Value: p.URI, Quoted: true},
+	}
+	if p.Independent {
+		attrs.add("INDEPENDENT", "YES", false)
+	}
+	if p.ByteRange != nil {
+		attrs.add("BYTERANGE", p.ByteRange.String(), true)
+	}
+	if p.Gap {
+		attrs.add("GAP", "YES", false)
+	}
+	return "#EXT-X-PART:" + attrs.String()
+}
+
+func parseServerControl(value string) *ServerControl {
+	control := &ServerControl{}
+	for _, attr := range ParseHLSAttributes(value) {
+		switch attr.Key {
+		case "CAN-SKIP-UNTIL":
+			control.CanSkipUntil, _ = strconv.ParseFloat(attr.Value, 64)
+		case "CAN-SKIP-DATERANGES":
+			control.CanSkipDateRanges = attr.Value == "YES"
+		case "HOLD-BACK":
+			control.HoldBack, _ = strconv.ParseFloat(attr.Value, 64)
+		case "PART-HOLD-BACK":
+			control.PartHoldBack, _ = strconv.ParseFloat(attr.Value, 64)
+		case "CAN-BLOCK-RELOAD":
+			control.CanBlockReload = attr.Value == "YES"
+		}
+	}
+	return control
+}
+
+func (c *ServerControl) String() string {
+	var attrs HLSAttributes
+	if c.CanBlockReload {
+		attrs.add("CAN-BLOCK-RELOAD", "YES", false)
+	}
+	if c.CanSkipUntil > 0 {
+		attrs.add("CAN-SKIP-UNTIL", strconv.FormatFloat(c.CanSkipUntil, 'f', -1, 64), false)
+	}
+	if c.CanSkipDateRanges {
+		attrs.add("CAN-SKIP-DATERANGES", "YES", false)
+	}
+	if c.HoldBack > 0 {
+		attrs.add("HOLD-BACK", strconv.FormatFloat(c.HoldBack, 'f', -1, 64), false)
+	}
+	if c.PartHoldBack > 0 {
+		attrs.add("PART-HOLD-BACK", strconv.FormatFloat(c.PartHoldBack, 'f', -1, 64), false)
+	}
+	return "#EXT-X-SERVER-CONTROL:" + attrs.String()
+}
+
+func parsePreloadHint(value string) PreloadHint {
+	attrs := ParseHLSAttributes(value)
+	hint := PreloadHint{
+		Type: attrs.GetValue("TYPE"),
+		URI:  attrs.GetValue("URI"),
+	}
+	if start, ok := attrs.Get("BYTERANGE-START"); ok {
+		hint.ByteRange = &ByteRange{Length: -1}
+		hint.ByteRange.Offset, _ = strconv.ParseInt(start, 10, 64)
+		if length, ok := attrs.Get("BYTERANGE-LENGTH"); ok {
+			hint.ByteRange.Length, _ = strconv.ParseInt(length, 10, 64)
+		}
+	}
+	return hint
+}
+
+func (h PreloadHint) String() string {
+	attrs := HLSAttributes{
+		{Key: "TYPE", Value: h.Type},
+		{Key: "URI", Value: h.URI, Quoted: true},
+	}
+	if h.ByteRange != nil {
+		attrs.add("BYTERANGE-START", strconv.FormatInt(h.ByteRange.Offset, 10), false)
+		if h.ByteRange.Length >= 0 {
+			attrs.add("BYTERANGE-LENGTH", strconv.FormatInt(h.ByteRange.Length, 10), false)
+		}
+	}
+	return "#EXT-X-PRELOAD-HINT:" + attrs.String()
+}
+
+func parseRenditionReport(value string) RenditionReport {
+	attrs := ParseHLSAttributes(value)
+	report := RenditionReport{
+		URI:      attrs.GetValue("URI"),
+		LastPart: -1,
+	}
+	report.LastMSN, _ = strconv.ParseInt(attrs.GetValue("LAST-MSN"), 10, 64)
+	if lastPart, ok := attrs.Get("LAST-PART"); ok {
+		report.LastPart, _ = strconv.ParseInt(lastPart, 10, 64)
+	}
+	return report
+}
+
+func (r RenditionReport) String() string {
+	attrs := HLSAttributes{
+		{Key: "URI", Value: r.URI, Quoted: true},
+		{Key: "LAST-MSN", Value: strconv.FormatInt(r.LastMSN, 10)},
+	}
+	if r.LastPart >= 0 {
+		attrs.add("LAST-PART", strconv.FormatInt(r.LastPart, 10), false)
+	}
+	return "#EXT-X-RENDITION-REPORT:" + attrs.String()
+}
+
+// readHLSLines returns the non empty lines of a playlist, after the header.
+func readHLSLines(r io.Reader) ([]string, error) {
+	utf8Reader, err := NewUTF8Reader(r, "")
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewReader(utf8Reader)
+	if err := assertM3UHeader(buf); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := readString(buf)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func isMasterPlaylist(lines []string) bool {
+	for _, line := range lines {
+		if tag, err := parseTag(line); err == nil {
+			if _, ok := masterDirectives[tag.Tag]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DecodeHLSPlaylist decodes a master or media playlist.
+func DecodeHLSPlaylist(r io.Reader) (HLSPlaylist, error) {
+	lines, err := readHLSLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if isMasterPlaylist(lines) {
+		return decodeMasterPlaylist(lines)
+	}
+	return decodeMediaPlaylist(lines)
+}
+
+func DecodeMasterPlaylist(r io.Reader) (*MasterPlaylist, error) {
+	lines, err := readHLSLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if !isMasterPlaylist(lines) {
+		return nil, errors.New("not a master playlist")
+	}
+	return decodeMasterPlaylist(lines)
+}
+
+func DecodeMediaPlaylist(r io.Reader) (*MediaPlaylist, error) {
+	lines, err := readHLSLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if isMasterPlaylist(lines) {
+		return nil, errors.New("not a media playlist")
+	}
+	return decodeMediaPlaylist(lines)
+}
+
+func decodeMasterPlaylist(lines []string) (*MasterPlaylist, error) {
+	playlist := &MasterPlaylist{}
+
+	var variant *Variant
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			if variant == nil {
+				return nil, errors.New("invalid master playlist")
+			}
+			variant.URI = line
+			playlist.Variants = append(playlist.Variants, variant)
+			variant = nil
+			continue
+		}
+
+		tag, err := parseTag(line)
+		if err != nil {
+			// Comment
+			continue
+		}
+
+		switch tag.Tag {
+		case "EXT-X-VERSION":
+			playlist.Version, _ = strconv.Atoi(tag.Value)
+		case "EXT-X-INDEPENDENT-SEGMENTS":
+			playlist.IndependentSegments = true
+		case "EXT-X-STREAM-INF":
+			variant = parseVariant(tag.Value, false)
+		case "EXT-X-I-FRAME-STREAM-INF":
+			playlist.Variants = append(playlist.Variants, parseVariant(tag.Value, true))
+		case "EXT-X-MEDIA":
+			playlist.Renditions = append(playlist.Renditions, parseRendition(tag.Value))
+		case "EXT-X-SESSION-KEY":
+			playlist.SessionKeys = append(playlist.SessionKeys, parseKey(tag.Value))
+		default:
+			playlist.Tags = append(playlist.Tags, tag)
+		}
+	}
+
+	return playlist, nil
+}
+
+func decodeMediaPlaylist(lines []string) (*MediaPlaylist, error) {
+	playlist := &MediaPlaylist{}
+
+	var keys []Key
+	keysFrom := 0
+	var initMap *Map
+	segment := &MediaSegment{}
+	pending := false
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			segment.URI = line
+			segment.Keys = keys
+			segment.Map = initMap
+			playlist.Segments = append(playlist.Segments, segment)
+			segment = &MediaSegment{}
+			pending = false
+			continue
+		}
+
+		tag, err := parseTag(line)
+		if err != nil {
+			// Comment
+			continue
+		}
+
+		switch tag.Tag {
+		case "EXT-X-VERSION":
+			playlist.Version, _ = strconv.Atoi(tag.Value)
+		case "EXT-X-TARGETDURATION":
+			playlist.TargetDuration, _ = strconv.Atoi(tag.Value)
+		case "EXT-X-MEDIA-SEQUENCE":
+			playlist.MediaSequence, _ = strconv.ParseInt(tag.Value, 10, 64)
+		case "EXT-X-DISCONTINUITY-SEQUENCE":
+			playlist.DiscontinuitySequence, _ = strconv.ParseInt(tag.Value, 10, 64)
+		case "EXT-X-PLAYLIST-TYPE":
+			playlist.PlaylistType = tag.Value
+		case "EXT-X-INDEPENDENT-SEGMENTS":
+			playlist.IndependentSegments = true
+		case "EXT-X-I-FRAMES-ONLY":
+			playlist.IFramesOnly = true
+		case "EXT-X-ENDLIST":
+			playlist.EndList = true
+		case "EXTINF":
+			duration, title, _ := strings.Cut(tag.Value, ",")
+			if segment.Duration, err = strconv.ParseFloat(strings.TrimSpace(duration), 64); err != nil {
+				return nil, fmt.Errorf("invalid segment duration: %w", err)
+			}
+			segment.Title = title
+			pending = true
+		case "EXT-X-BYTERANGE":
+			if segment.ByteRange, err = ParseByteRange(tag.Value); err != nil {
+				return nil, fmt.Errorf("invalid byte range: %w", err)
+			}
+			pending = true
+		case "EXT-X-KEY":
+			// Consecutive keys apply together, with different key formats
+			if keysFrom != len(playlist.Segments) {
+				keys = nil
+				keysFrom = len(playlist.Segments)
+			}
+			if key := parseKey(tag.Value); key.Method == "NONE" {
+				keys = nil
+			} else {
+				keys = append(keys[:len(keys):len(keys)], key)
+			}
+			pending = true
+		case "EXT-X-MAP":
+			if initMap, err = parseMap(tag.Value); err != nil {
+				return nil, fmt.Errorf("invalid map: %w", err)
+			}
+			pending = true
+		case "EXT-X-PROGRAM-DATE-TIME":
+			if segment.ProgramDateTime, err = time.Parse(time.RFC3339Nano, tag.Value); err != nil {
+				return nil, fmt.Errorf("invalid program date time: %w", err)
+			}
+			pending = true
+		case "EXT-X-DISCONTINUITY":
+			segment.Discontinuity = true
+			pending = true
+		case "EXT-X-GAP":
+			segment.Gap = true
+			pending = true
+		case "EXT-X-PART":
+			part, err := parsePart(tag.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid part: %w", err)
+			}
+			segment.Parts = append(segment.Parts, part)
+			pending = true
+		case "EXT-X-PART-INF":
+			playlist.PartTarget, _ = strconv.ParseFloat(ParseHLSAttributes(tag.Value).GetValue("PART-TARGET"), 64)
+		case "EXT-X-SERVER-CONTROL":
+			playlist.ServerControl = parseServerControl(tag.Value)
+		case "EXT-X-SKIP":
+			playlist.SkippedSegments, _ = strconv.ParseInt(ParseHLSAttributes(tag.Value).GetValue("SKIPPED-SEGMENTS"), 10, 64)
+		case "EXT-X-PRELOAD-HINT":
+			playlist.PreloadHints = append(playlist.PreloadHints, parsePreloadHint(tag.Value))
+		case "EXT-X-RENDITION-REPORT":
+			playlist.RenditionReports = append(playlist.RenditionReports, parseRenditionReport(tag.Value))
+		default:
+			if len(playlist.Segments) == 0 && !pending {
+				playlist.Tags = append(playlist.Tags, tag)
+			} else {
+				segment.Tags = append(segment.Tags, tag)
+			}
+		}
+	}
+
+	// Parts and tags that don't precede a complete segment
+	playlist.Parts = segment.Parts
+	playlist.TrailingTags = segment.Tags
+
+	return playlist, nil
+}
+
+func writeTags(buf *bytes.Buffer, tags M3UTags) {
+	for _, tag := range tags {
+		buf.WriteString(tag.String() + "\n")
+	}
+}
+
+func (p *MasterPlaylist) encode() *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n")
+	if p.Version > 0 {
+		fmt.Fprintf(buf, "#EXT-X-VERSION:%d\n", p.Version)
+	}
+	if p.IndependentSegments {
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	writeTags(buf, p.Tags)
+	for _, key := range p.SessionKeys {
+		buf.WriteString("#EXT-X-SESSION-KEY:" + key.String() + "\n")
+	}
+	for _, rendition := range p.Renditions {
+		buf.WriteString(rendition.String() + "\n")
+	}
+	for _, variant := range p.Variants {
+		buf.WriteString(variant.String() + "\n")
+	}
+	return buf
+}
+
+func (p *MasterPlaylist) String() string {
+	return p.encode().String()
+}
+
+func (p *MasterPlaylist) WriteTo(w io.Writer) (int64, error) {
+	return p.encode().WriteTo(w)
+}
+
+func keysEqual(a []Key, b []Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *MediaPlaylist) encode() *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n")
+	if p.Version > 0 {
+		fmt.Fprintf(buf, "#EXT-X-VERSION:%d\n", p.Version)
+	}
+	fmt.Fprintf(buf, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration)
+	if p.ServerControl != nil {
+		buf.WriteString(p.ServerControl.String() + "\n")
+	}
+	if p.PartTarget > 0 {
+		buf.WriteString("#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(p.PartTarget, 'f', -1, 64) + "\n")
+	}
+	fmt.Fprintf(buf, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.MediaSequence)
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(buf, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+	if p.PlaylistType != "" {
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:" + p.PlaylistType + "\n")
+	}
+	if p.IndependentSegments {
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if p.IFramesOnly {
+		buf.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	}
+	writeTags(buf, p.Tags)
+	if p.SkippedSegments > 0 {
+		fmt.Fprintf(buf, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", p.SkippedSegments)
+	}
+
+	var keys []Key
+	var initMap *Map
+	for _, segment := range p.Segments {
+		writeTags(buf, segment.Tags)
+		if segment.Discontinuity {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if !keysEqual(keys, segment.Keys) {
+			if len(segment.Keys) == 0 {
+				buf.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+			}
+			for _, key := range segment.Keys {
+				buf.WriteString("#EXT-X-KEY:" + key.String() + "\n")
+			}
+			keys = segment.Keys
+		}
+		if segment.Map != nil && !segment.Map.equal(initMap) {
+			buf.WriteString("#EXT-X-MAP:" + segment.Map.String() + "\n")
+			initMap = segment.Map
+		}
+		if !segment.ProgramDateTime.IsZero() {
+			buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + segment.ProgramDateTime.Format(programDateTimeLayout) + "\n")
+		}
+		if segment.Gap {
+			buf.WriteString("#EXT-X-GAP\n")
+		}
+		for _, part := range segment.Parts {
+			buf.WriteString(part.String() + "\n")
+		}
+		if segment.ByteRange != nil {
+			buf.WriteString("#EXT-X-BYTERANGE:" + segment.ByteRange.String() + "\n")
+		}
+		buf.WriteString("#EXTINF:" + strconv.FormatFloat(segment.Duration, 'f', -1, 64) + "," + segment.Title + "\n")
+		buf.WriteString(segment.URI + "\n")
+	}
+
+	for _, part := range p.Parts {
+		buf.WriteString(part.String() + "\n")
+	}
+	writeTags(buf, p.TrailingTags)
+	for _, hint := range p.PreloadHints {
+		buf.WriteString(hint.String() + "\n")
+	}
+	for _, report := range p.RenditionReports {
+		buf.WriteString(report.String() + "\n")
+	}
+	if p.EndList {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return buf
+}
+
+func (p *MediaPlaylist) String() string {
+	return p.encode().String()
+}
+
+func (p *MediaPlaylist) WriteTo(w io.Writer) (int64, error) {
+	return p.encode().WriteTo(w)
+}
@@ -0,0 +1,179 @@
+package m3uparser
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMasterPlaylist(t *testing.T) {
+	file, err := os.Open("../../tests/test1.m3u8")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	playlist, err := DecodeMasterPlaylist(file)
+	if err != nil {
+		t.Fatalf("Failed to decode master playlist: %v", err)
+	}
+
+	if len(playlist.Variants) != 4 {
+		t.Fatalf("Unexpected number of variants. Expected: 4, Got: %d", len(playlist.Variants))
+	}
+
+	variant := playlist.Variants[0]
+	if variant.Bandwidth != 1563015 || variant.Resolution == nil || variant.Resolution.Height != 720 {
+		t.Errorf("Unexpected variant. Got: %s", variant.String())
+	}
+	if len(variant.Codecs) != 2 || variant.Codecs[0] != "avc1.640029" || variant.Codecs[1] != "mp4a.40.2" {
+		t.Errorf("Unexpected codecs. Got: %v", variant.Codecs)
+	}
+	if variant.URI != "edge_servers/720_passthrough/chunks.m3u8" {
+		t.Errorf("Unexpected URI. Got: %s", variant.URI)
+	}
+}
+
+func TestSetHLSAttributes(t *testing.T) {
+	attrs := ParseHLSAttributes(`TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="pt",DEFAULT=NO`)
+	attrs.Set("DEFAULT", "YES")
+	attrs.Set("AUTOSELECT", "YES")
+	attrs.Set("LANGUAGE", "en")
+
+	expected := `TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES`
+	if attrs.String() != expected {
+		t.Errorf("Unexpected attributes. Expected: %s, Got: %s", expected, attrs.String())
+	}
+}
+
+func TestEncodeMasterPlaylist(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2",URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=1280x720,FRAME-RATE=25.000,AUDIO="aac"
+video/720p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=200000,RESOLUTION=1280x720,URI="video/720p-iframes.m3u8"
+`
+
+	playlist, err := DecodeHLSPlaylist(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to decode playlist: %v", err)
+	}
+
+	master, ok := playlist.(*MasterPlaylist)
+	if !ok {
+		t.Fatalf("Expected a master playlist")
+	}
+
+	if len(master.Renditions) != 1 || master.Renditions[0].Language != "en" || !master.Renditions[0].Default {
+		t.Errorf("Unexpected renditions. Got: %v", master.Renditions)
+	}
+	if len(master.Variants) != 2 || master.Variants[0].FrameRate != 25 || !master.Variants[1].IFrame {
+		t.Errorf("Unexpected variants. Got: %v", master.Variants)
+	}
+
+	if master.String() != content {
+		t.Errorf("Unexpected content. Expected: %s, Got: %s", content, master.String())
+	}
+}
+
+func TestEncodeMediaPlaylist(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-DISCONTINUITY-SEQUENCE:2
+#EXT-X-KEY:METHOD=AES-128,URI="key1",IV=0x1
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000Z
+#EXTINF:5.005,
+seg100.m4s
+#EXT-X-BYTERANGE:1000@200
+#EXTINF:6,title
+seg101.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.5,
+seg102.m4s
+#EXT-X-ENDLIST
+`
+
+	playlist, err := DecodeMediaPlaylist(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to decode media playlist: %v", err)
+	}
+
+	if playlist.MediaSequence != 100 || playlist.DiscontinuitySequence != 2 || !playlist.EndList {
+		t.Errorf("Unexpected playlist header. Got: %+v", playlist)
+	}
+
+	if len(playlist.Segments) != 3 {
+		t.Fatalf("Unexpected number of segments. Expected: 3, Got: %d", len(playlist.Segments))
+	}
+
+	first := playlist.Segments[0]
+	if first.Duration != 5.005 || len(first.Keys) != 1 || first.Keys[0].URI != "key1" || first.Map == nil || first.ProgramDateTime.IsZero() {
+		t.Errorf("Unexpected first segment. Got: %+v", first)
+	}
+
+	second := playlist.Segments[1]
+	if second.ByteRange == nil || second.ByteRange.Length != 1000 || second.ByteRange.Offset != 200 || second.Title != "title" {
+		t.Errorf("Unexpected second segment. Got: %+v", second)
+	}
+	if len(second.Keys) != 1 || !second.ProgramDateTime.IsZero() {
+		t.Errorf("Unexpected second segment state. Got: %+v", second)
+	}
+
+	third := playlist.Segments[2]
+	if !third.Discontinuity || len(third.Keys) != 0 || third.Map == nil {
+		t.Errorf("Unexpected third segment. Got: %+v", third)
+	}
+
+	if playlist.String() != content {
+		t.Errorf("Unexpected content. Expected: %s, Got: %s", content, playlist.String())
+	}
+}
+
+func TestEncodeLowLatencyMediaPlaylist(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=24,PART-HOLD-BACK=3.012
+#EXT-X-PART-INF:PART-TARGET=1.004
+#EXT-X-MEDIA-SEQUENCE:266
+#EXT-X-SKIP:SKIPPED-SEGMENTS=3
+#EXT-X-PART:DURATION=1.004,URI="seg269.0.mp4",INDEPENDENT=YES
+#EXT-X-PART:DURATION=1.004,URI="seg269.1.mp4"
+#EXTINF:4,
+seg269.mp4
+#EXT-X-PART:DURATION=1.004,URI="seg270.0.mp4",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg270.1.mp4"
+#EXT-X-RENDITION-REPORT:URI="../1M/waitForMSN.php",LAST-MSN=270,LAST-PART=0
+`
+
+	playlist, err := DecodeMediaPlaylist(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to decode media playlist: %v", err)
+	}
+
+	if playlist.ServerControl == nil || !playlist.ServerControl.CanBlockReload || playlist.ServerControl.PartHoldBack != 3.012 {
+		t.Errorf("Unexpected server control. Got: %+v", playlist.ServerControl)
+	}
+	if playlist.PartTarget != 1.004 || playlist.SkippedSegments != 3 {
+		t.Errorf("Unexpected playlist header. Got: %+v", playlist)
+	}
+	if len(playlist.Segments) != 1 || len(playlist.Segments[0].Parts) != 2 || !playlist.Segments[0].Parts[0].Independent {
+		t.Errorf("Unexpected segments. Got: %+v", playlist.Segments)
+	}
+	if len(playlist.Parts) != 1 || len(playlist.PreloadHints) != 1 || playlist.PreloadHints[0].URI != "seg270.1.mp4" {
+		t.Errorf("Unexpected parts or hints. Got: %+v, %+v", playlist.Parts, playlist.PreloadHints)
+	}
+	if len(playlist.RenditionReports) != 1 || playlist.RenditionReports[0].LastMSN != 270 || playlist.RenditionReports[0].LastPart != 0 {
+		t.Errorf("Unexpected rendition reports. Got: %+v", playlist.RenditionReports)
+	}
+
+	if playlist.String() != content {
+		t.Errorf("Unexpected content. Expected: %s, Got: %s", content, playlist.String())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"iter"
 	"net/http"
 	"os"
 	"strconv"
@@ -51,6 +52,13 @@ var (
 		"EXT-X-SESSION-DATA",
 		"EXT-X-SESSION-KEY",
 		"EXT-X-ENDLIST",
+		// Low latency HLS extensions
+		"EXT-X-PART",
+		"EXT-X-PART-INF",
+		"EXT-X-PRELOAD-HINT",
+		"EXT-X-SERVER-CONTROL",
+		"EXT-X-RENDITION-REPORT",
+		"EXT-X-SKIP",
 		// VLC M3U extensions
 		"EXTVLCOPT",
 		// Kodi M3U extensions
@@ -73,8 +81,11 @@ func contains(item string) bool {
 	return exists
 }
 
-func ParseM3UFile(filePath string) (*M3UPlaylist, error) {
-	var reader io.ReadCloser
+// OpenM3UFile opens a playlist from a local path or an http(s) URL, the
+// content is converted to UTF-8 from the given charset or, if empty, from the
+// charset of the HTTP Content-Type.
+func OpenM3UFile(filePath string, charset string) (io.ReadCloser, error) {
+	var file io.ReadCloser
 
 	if strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://") {
 		// Load content from URL
@@ -82,23 +93,32 @@ func ParseM3UFile(filePath string) (*M3UPlaylist, error) {
 		if err != nil {
 			return nil, err
 		}
-
-		defer resp.Body.Close()
-
-		reader = resp.Body
-
+		if charset == "" {
+			charset = CharsetFromContentType(resp.Header.Get("Content-Type"))
+		}
+		file = resp.Body
 	} else {
-
 		// Load content from local file
-		file, err := os.Open(filePath)
-		if err != nil {
+		var err error
+		if file, err = os.Open(filePath); err != nil {
 			return nil, err
 		}
+	}
 
-		defer file.Close()
+	reader, err := NewUTF8Reader(file, charset)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return readCloser{Reader: reader, Closer: file}, nil
+}
 
-		reader = file
+func ParseM3UFile(filePath string) (*M3UPlaylist, error) {
+	reader, err := OpenM3UFile(filePath, "")
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
 
 	return DecodeFromReader(reader)
 }
@@ -113,7 +133,7 @@ func readString(buf *bufio.Reader) (string, error) {
 
 func assertM3UHeader(buf *bufio.Reader) error {
 	line, err := readString(buf)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return err
 	}
 	if !strings.HasPrefix(line, "#EXTM3U") {
@@ -122,9 +142,68 @@ func assertM3UHeader(buf *bufio.Reader) error {
 	return nil
 }
 
-func processLine(buf *bufio.Reader) (M3UTag, string, error) {
+// isDirective tells lines starting with '#' that are directives from comments.
+func isDirective(tag string) bool {
+	return contains(tag) || strings.HasPrefix(tag, "EXT")
+}
+
+// Decoder reads the entries of a playlist one at a time, so large playlists
+// don't need to be held in memory.
+type Decoder struct {
+	buf         *bufio.Reader
+	header      bool
+	count       int
+	HeaderAttrs M3UExtinfTags // attributes of the #EXTM3U header
+	Tags        M3UTags       // playlist tags read so far
+	Type        string
+
+	// Strict fails on malformed entries, otherwise they are reported in
+	// Warnings and decoded on a best effort basis.
+	Strict   bool
+	Warnings []*ParseError
+	line     int
+
+	// Lossless keeps unknown directives and comments, set it before reading
+	// the first entry. Tags between entries are kept with the entry that
+	// follows them and the raw input is kept, so WriteTo reproduces it.
+	Lossless  bool
+	pending   M3UTags
+	raw       []byte
+	lineStart int
+	rawHead   []byte
+}
+
+// NewDecoder returns a decoder reading UTF-8 from r, a byte order mark is
+// honored.
+func NewDecoder(r io.Reader) *Decoder {
+	if utf8Reader, err := NewUTF8Reader(r, ""); err == nil {
+		r = utf8Reader
+	}
+	return &Decoder{
+		buf:  bufio.NewReader(r),
+		Tags: make([]M3UTag, 0),
+		Type: "master",
+	}
+}
+
+func (d *Decoder) readLine() (string, error) {
+	line, err := d.buf.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if line != "" {
+		d.line++
+	}
+	if d.Lossless {
+		d.lineStart = len(d.raw)
+		d.raw = append(d.raw, line...)
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (d *Decoder) processLine() (M3UTag, string, error) {
 	for {
-		line, err := readString(buf)
+		line, err := d.readLine()
 		if err != nil && line == "" {
 			if err == io.EOF {
 				return M3UTag{}, "", io.EOF
@@ -133,7 +212,10 @@ func processLine(buf *bufio.Reader) (M3UTag, string, error) {
 		}
 
 		line = strings.TrimSpace(line)
-		if len(line) == 0 || !strings.HasPrefix(line, "#") {
+		if len(line) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
 			// Return non-tag lines as URIs
 			return M3UTag{}, line, nil
 		}
@@ -142,79 +224,171 @@ func processLine(buf *bufio.Reader) (M3UTag, string, error) {
 		if err == nil && contains(tag.Tag) {
 			return tag, "", nil
 		}
+		if d.Lossless {
+			if err == nil && isDirective(tag.Tag) {
+				return tag, "", nil
+			}
+			// Comment
+			return M3UTag{Value: line[1:]}, "", nil
+		}
 	}
 }
 
-func DecodeFromReader(r io.Reader) (*M3UPlaylist, error) {
-	buf := bufio.NewReader(r)
-
-	// Validate header
-	if err := assertM3UHeader(buf); err != nil {
-		return nil, err
-	}
-
-	playlist := &M3UPlaylist{
-		Version: M3U8Version3,
-		Entries: make([]M3UEntry, 0),
-		Tags:    make([]M3UTag, 0),
-		Type:    "master",
+// Next returns the next entry of the playlist, io.EOF after the last one.
+func (d *Decoder) Next() (M3UEntry, error) {
+	if !d.header {
+		// Validate header
+		line, err := d.readLine()
+		if err != nil && err != io.EOF {
+			return M3UEntry{}, err
+		}
+		if !strings.HasPrefix(line, "#EXTM3U") {
+			return M3UEntry{}, &ParseError{Line: 1, Msg: "invalid M3U file, missing #EXTM3U header"}
+		}
+		d.HeaderAttrs, _, _ = parseAttrs(line, len("#EXTM3U"))
+		d.header = true
 	}
 
 	var currentEntry *M3UEntry
 
 	for {
-		tag, line, err := processLine(buf)
-		if err == io.EOF {
-			break
+		tag, line, err := d.processLine()
+		if err == io.EOF && d.count == 0 && d.rawHead == nil {
+			d.rawHead, d.raw = d.raw, nil
 		}
 		if err != nil {
-			return nil, err
+			return M3UEntry{}, err
 		}
 
 		if line != "" {
-			if currentEntry != nil {
-				currentEntry.URI = line
-				playlist.Entries = append(playlist.Entries, *currentEntry)
-				currentEntry = nil
-			} else {
-				return nil, errors.New("invalid M3U file")
+			if currentEntry == nil {
+				if err := d.problem(&ParseError{Line: d.line, Msg: "URI without #EXTINF"}); err != nil {
+					return M3UEntry{}, err
+				}
+				continue
 			}
-			continue
+			currentEntry.URI = line
+			if d.Lossless {
+				currentEntry.raw = string(d.raw)
+				currentEntry.rawKey = currentEntry.String()
+				d.raw = d.raw[:0]
+			}
+			d.count++
+			return *currentEntry, nil
 		}
 
 		switch tag.Tag {
-		case "EXTINF":
-			currentEntry = &M3UEntry{
-				Tags: []M3UTag{tag},
+		case "EXTINF", "EXT-X-STREAM-INF":
+			if d.Lossless && d.count == 0 && d.rawHead == nil {
+				d.rawHead = d.raw[:d.lineStart]
+				d.raw = append([]byte(nil), d.raw[d.lineStart:]...)
 			}
-			parts := strings.SplitN(tag.Value, ",", 2)
-			if len(parts) > 0 {
-				currentEntry.Duration = parseDuration(parts[0])
-				if currentEntry.Duration == -1 {
-					currentEntry.ExtInfTags = ExtractExtinfTags(parts[0][2:])
-				}
-			} else {
-				currentEntry.Duration = -1
+			currentEntry = &M3UEntry{
+				Tags: append(d.pending, tag),
 			}
-			if len(parts) > 1 {
-				currentEntry.Title = parts[1]
+			d.pending = nil
+			if tag.Tag == "EXT-X-STREAM-INF" {
+				continue
 			}
-		case "EXT-X-STREAM-INF":
-			currentEntry = &M3UEntry{
-				Tags: []M3UTag{tag},
+			extinf, problems := ParseExtinf(tag.Value)
+			for _, problem := range problems {
+				problem.Line = d.line
+				if err := d.problem(problem); err != nil {
+					return M3UEntry{}, err
+				}
 			}
+			currentEntry.Duration = int(extinf.Duration)
+			currentEntry.ExtInfTags = extinf.Attrs
+			currentEntry.Title = extinf.Title
 		default:
 			if currentEntry != nil {
 				currentEntry.Tags = append(currentEntry.Tags, tag)
+			} else if d.Lossless && d.count > 0 {
+				d.pending = append(d.pending, tag)
 			} else {
 				if tag.Tag == "EXT-X-INDEPENDENT-SEGMENTS" {
-					playlist.Type = "master"
+					d.Type = "master"
 				} else if tag.Tag == "EXT-X-MEDIA-SEQUENCE" {
-					playlist.Type = "media"
+					d.Type = "media"
 				}
-				playlist.Tags = append(playlist.Tags, tag)
+				d.Tags = append(d.Tags, tag)
+			}
+		}
+	}
+}
+
+// problem fails in strict mode and records a warning otherwise.
+func (d *Decoder) problem(err *ParseError) error {
+	if d.Strict {
+		return err
+	}
+	d.Warnings = append(d.Warnings, err)
+	return nil
+}
+
+// Entries iterates over the entries of the playlist, iteration stops after
+// the first error.
+func (d *Decoder) Entries() iter.Seq2[M3UEntry, error] {
+	return func(yield func(M3UEntry, error) bool) {
+		for {
+			entry, err := d.Next()
+			if err == io.EOF {
+				return
 			}
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func DecodeFromReader(r io.Reader) (*M3UPlaylist, error) {
+	return decode(NewDecoder(r))
+}
+
+// DecodeLossless decodes a playlist keeping unknown directives, comments and
+// the original ordering, its WriteTo reproduces the input when nothing was
+// edited.
+func DecodeLossless(r io.Reader) (*M3UPlaylist, error) {
+	decoder := NewDecoder(r)
+	decoder.Lossless = true
+	return decode(decoder)
+}
+
+// DecodeStrict decodes a playlist failing with a *ParseError on the first
+// malformed entry.
+func DecodeStrict(r io.Reader) (*M3UPlaylist, error) {
+	decoder := NewDecoder(r)
+	decoder.Strict = true
+	return decode(decoder)
+}
+
+func decode(decoder *Decoder) (*M3UPlaylist, error) {
+	playlist := &M3UPlaylist{
+		Version: M3U8Version3,
+		Entries: make([]M3UEntry, 0),
+	}
+
+	for entry, err := range decoder.Entries() {
+		if err != nil {
+			return nil, err
+		}
+		playlist.Entries = append(playlist.Entries, entry)
+	}
+
+	playlist.HeaderAttrs = decoder.HeaderAttrs
+	playlist.Tags = decoder.Tags
+	playlist.Type = decoder.Type
+	playlist.Warnings = decoder.Warnings
+
+	if decoder.Lossless {
+		playlist.Trailer = decoder.pending
+		playlist.raw = &rawPlaylist{
+			head:    string(decoder.rawHead),
+			trailer: string(decoder.raw),
 		}
+		playlist.raw.headKey = playlist.headString()
+		playlist.raw.trailerKey = playlist.trailerString()
 	}
 
 	if playlist.Version == 0 {
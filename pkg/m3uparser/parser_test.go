@@ -3,6 +3,7 @@ package m3uparser
 import (
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -196,3 +197,121 @@ func TestParseDuration(t *testing.T) {
 		t.Errorf("Unexpected duration. Expected: %d, Got: %d", expectedDuration, duration)
 	}
 }
+
+func TestDecoderEntries(t *testing.T) {
+	file, err := os.Open("../../tests/test0.m3u8")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	var titles []string
+	for entry, err := range NewDecoder(file).Entries() {
+		if err != nil {
+			t.Fatalf("Failed to decode entry: %v", err)
+		}
+		titles = append(titles, entry.Title)
+	}
+
+	// Assert that the number of entries is correct
+	expectedNumEntries := 3
+	if len(titles) != expectedNumEntries {
+		t.Errorf("Unexpected number of entries. Expected: %d, Got: %d", expectedNumEntries, len(titles))
+	}
+
+	if len(titles) > 0 && titles[0] != "Channel 1" {
+		t.Errorf("Unexpected title. Expected: Channel 1, Got: %s", titles[0])
+	}
+}
+
+func TestDecodeLossless(t *testing.T) {
+	content := "#EXTM3U url-tvg=\"http://example.com/epg.xml\" x-tvg-url=\"http://example.com/epg2.xml\"\r\n" +
+		"#EXT-X-CUSTOM:value\r\n" +
+		"# A comment\r\n" +
+		"\r\n" +
+		"#EXTINF:-1 tvg-id=\"Channel 1\" group-title=\"TV\",Channel 1\r\n" +
+		"#EXTVLCOPT:http-user-agent=Firefox\r\n" +
+		"http://example.com/channel1.m3u8\r\n" +
+		"# Between entries\r\n" +
+		"#EXTINF:-1 tvg-id=\"Channel 2\",Channel 2\r\n" +
+		"http://example.com/channel2.m3u8\r\n" +
+		"#EXT-X-ENDLIST"
+
+	playlist, err := DecodeLossless(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse M3U file: %v", err)
+	}
+
+	if playlist.HeaderAttrs.GetValue("url-tvg") != "http://example.com/epg.xml" {
+		t.Errorf("Unexpected header attributes. Got: %v", playlist.HeaderAttrs)
+	}
+
+	// Assert that unknown directives and comments are kept in order
+	expectedTags := []M3UTag{
+		{"EXT-X-CUSTOM", "value"},
+		{"", " A comment"},
+	}
+	if len(playlist.Tags) != len(expectedTags) {
+		t.Fatalf("Unexpected number of tags. Expected: %d, Got: %d", len(expectedTags), len(playlist.Tags))
+	}
+	for i, tag := range expectedTags {
+		if playlist.Tags[i] != tag {
+			t.Errorf("Unexpected tag. Expected: %v, Got: %v", tag, playlist.Tags[i])
+		}
+	}
+
+	if len(playlist.Entries) != 2 || playlist.Entries[1].Tags[0].Value != " Between entries" {
+		t.Errorf("Unexpected entries. Got: %v", playlist.Entries)
+	}
+	if len(playlist.Trailer) != 1 || playlist.Trailer[0].Tag != "EXT-X-ENDLIST" {
+		t.Errorf("Unexpected trailer. Got: %v", playlist.Trailer)
+	}
+
+	var output strings.Builder
+	playlist.WriteTo(&output)
+	if output.String() != content {
+		t.Errorf("Unexpected content. Expected: %q, Got: %q", content, output.String())
+	}
+
+	// Only the edited entry is written again
+	playlist.Entries[0].URI = "http://example.com/edited.m3u8"
+	expectedContent := strings.Replace(content,
+		"#EXTINF:-1 tvg-id=\"Channel 1\" group-title=\"TV\",Channel 1\r\n"+
+			"#EXTVLCOPT:http-user-agent=Firefox\r\n"+
+			"http://example.com/channel1.m3u8\r\n",
+		"#EXTINF:-1 tvg-id=\"Channel 1\" group-title=\"TV\",Channel 1\n"+
+			"#EXTVLCOPT:http-user-agent=Firefox\n"+
+			"http://example.com/edited.m3u8\n", 1)
+
+	output.Reset()
+	playlist.WriteTo(&output)
+	if output.String() != expectedContent {
+		t.Errorf("Unexpected content. Expected: %q, Got: %q", expectedContent, output.String())
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXTINF:-1 tvg-id=\"Channel 1\",Channel 1\n" +
+		"http://example.com/channel1.m3u8\n" +
+		"#EXTINF:-1 tvg-id=\"Channel 2,Channel 2\n" +
+		"http://example.com/channel2.m3u8\n"
+
+	_, err := DecodeStrict(strings.NewReader(content))
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("Expected a ParseError, Got: %v", err)
+	}
+	if parseErr.Line != 4 {
+		t.Errorf("Unexpected line. Expected: 4, Got: %d", parseErr.Line)
+	}
+
+	// Lenient mode decodes the entry and reports a warning
+	playlist, err := DecodeFromReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse M3U file: %v", err)
+	}
+	if len(playlist.Entries) != 2 || len(playlist.Warnings) != 1 || playlist.Warnings[0].Line != 4 {
+		t.Errorf("Unexpected result. Got: %d entries, warnings %v", len(playlist.Entries), playlist.Warnings)
+	}
+}
@@ -7,10 +7,24 @@ import (
 
 // M3UPlaylist represents the parsed M3U playlist.
 type M3UPlaylist struct {
-	Version int        // The version of the M3U (EXTM3U).
-	Entries M3UEntries // The list of media entries in the playlist.
-	Tags    M3UTags    // Additional tags associated with the entry.
-	Type    string     // The type of the media (if available).
+	Version     int           // The version of the M3U (EXTM3U).
+	HeaderAttrs M3UExtinfTags // Attributes of the #EXTM3U header (url-tvg, x-tvg-url...).
+	Entries     M3UEntries    // The list of media entries in the playlist.
+	Tags        M3UTags       // Additional tags associated with the entry.
+	Trailer     M3UTags       // Tags following the last entry (lossless mode).
+	Type        string        // The type of the media (if available).
+	Warnings    []*ParseError // Malformed entries decoded on a best effort basis.
+
+	raw *rawPlaylist
+}
+
+// rawPlaylist holds the input around the entries of a playlist decoded in
+// lossless mode, the keys tell if the playlist was edited since.
+type rawPlaylist struct {
+	head       string
+	headKey    string
+	trailer    string
+	trailerKey string
 }
 
 func (playlist *M3UPlaylist) GetVersion() int {
@@ -21,37 +35,81 @@ func (playlist *M3UPlaylist) GetEntries() M3UEntries {
 	return playlist.Entries
 }
 
+func (playlist *M3UPlaylist) header() string {
+	header := "#EXTM3U"
+	for _, attr := range playlist.HeaderAttrs {
+		header += " " + attr.String()
+	}
+	return header
+}
+
+func (playlist *M3UPlaylist) headString() string {
+	result := playlist.header() + "\n"
+	for _, tag := range playlist.Tags {
+		result += tag.String() + "\n"
+	}
+	return result
+}
+
+func (playlist *M3UPlaylist) trailerString() string {
+	var result string
+	for _, tag := range playlist.Trailer {
+		result += tag.String() + "\n"
+	}
+	return result
+}
+
 func (playlist *M3UPlaylist) EntriesString() string {
 	var result string
 	for _, tag := range playlist.Tags {
-		result += "#" + tag.Tag + ":" + tag.Value + "\n"
+		result += tag.String() + "\n"
 	}
 	for _, entry := range playlist.Entries {
 		result += entry.String() + "\n"
 	}
+	result += playlist.trailerString()
 	return strings.Trim(result, "\n")
 }
 
 func (playlist *M3UPlaylist) String() string {
 	var result string
-	result += "#EXTM3U\n"
+	result += playlist.header() + "\n"
 	result += playlist.EntriesString()
 	return result
 }
 
+// WriteTo writes the playlist, a playlist decoded in lossless mode is written
+// as it was read except for the parts edited since.
 func (playlist *M3UPlaylist) WriteTo(writer io.Writer) (int64, error) {
-	n, err := writer.Write([]byte("#EXTM3U\n"))
+	head := playlist.headString()
+	trailer := playlist.trailerString()
+	if playlist.raw != nil {
+		if head == playlist.raw.headKey {
+			head = playlist.raw.head
+		}
+		if trailer == playlist.raw.trailerKey {
+			trailer = playlist.raw.trailer
+		}
+	}
+
+	n, err := io.WriteString(writer, head)
 	if err != nil {
 		return int64(n), err
 	}
-	for _, tag := range playlist.Tags {
-		nBytes, _ := writer.Write([]byte("#" + tag.Tag + ":" + tag.Value + "\n"))
+	for i, entry := range playlist.Entries {
+		encoded := entry.encode()
+		// Only the input's last line may lack a line break
+		if !strings.HasSuffix(encoded, "\n") && (i < len(playlist.Entries)-1 || trailer != "") {
+			encoded += "\n"
+		}
+		nBytes, err := io.WriteString(writer, encoded)
 		n += nBytes
+		if err != nil {
+			return int64(n), err
+		}
 	}
-	for _, entry := range playlist.Entries {
-		nBytes, _ := entry.WriteTo(writer)
-		n += int(nBytes)
-	}
+	nBytes, err := io.WriteString(writer, trailer)
+	n += nBytes
 	return int64(n), err
 }
 
@@ -1,5 +1,11 @@
 package m3uparser
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type M3UTvgTag struct {
 	Tag   string
 	Value string
@@ -7,41 +13,150 @@ type M3UTvgTag struct {
 
 type M3UExtinfTags []M3UTvgTag
 
-func ExtractExtinfTags(data string) M3UExtinfTags {
+// ParseError is a malformed line of a playlist, Column is the 1-based byte
+// offset in the tag value (0 if unknown).
+type ParseError struct {
+	Line   int
+	Column int
+	Msg    string
+}
 
-	inKey := true
-	var key string
-	var value string
-	var tags []M3UTvgTag
-	for token := range data {
-		if inKey && (data[token] == ' ' || data[token] == '=') {
-			continue
+func (e *ParseError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Extinf is the value of an EXTINF tag: #EXTINF:<duration> <attrs>,<title>
+type Extinf struct {
+	Duration float64
+	Attrs    M3UExtinfTags
+	Title    string
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// ParseExtinf tokenizes the value of an EXTINF tag. Problems found are
+// returned without a line number, the best effort result is always returned.
+func ParseExtinf(value string) (Extinf, []*ParseError) {
+	extinf := Extinf{}
+	var problems []*ParseError
+
+	i := 0
+	for i < len(value) && isSpace(value[i]) {
+		i++
+	}
+	start := i
+	for i < len(value) && !isSpace(value[i]) && value[i] != ',' {
+		i++
+	}
+
+	duration, err := strconv.ParseFloat(value[start:i], 64)
+	if err != nil {
+		problems = append(problems, &ParseError{Column: start + 1, Msg: fmt.Sprintf("invalid duration %q", value[start:i])})
+		duration = -1
+	}
+	extinf.Duration = duration
+
+	attrs, end, attrProblems := parseAttrs(value, i)
+	extinf.Attrs = attrs
+	problems = append(problems, attrProblems...)
+
+	if end >= len(value) {
+		problems = append(problems, &ParseError{Column: len(value) + 1, Msg: "missing title"})
+		return extinf, problems
+	}
+	extinf.Title = value[end+1:]
+	return extinf, problems
+}
+
+// parseAttrs tokenizes key=value attributes starting at offset, values are
+// unquoted, single or double quoted with backslash escapes. It stops at the
+// first comma outside quotes and returns its offset, len(data) if none.
+func parseAttrs(data string, offset int) (M3UExtinfTags, int, []*ParseError) {
+	var attrs M3UExtinfTags
+	var problems []*ParseError
+
+	i := offset
+	for {
+		for i < len(data) && isSpace(data[i]) {
+			i++
 		}
-		if inKey && data[token] == ',' {
-			// Break, we reached the end of the tags
-			break
+		if i >= len(data) || data[i] == ',' {
+			return attrs, i, problems
 		}
-		if data[token] == '"' {
-			if !inKey {
-				tags = append(tags, M3UTvgTag{Tag: key, Value: value})
-				key = ""
-				value = ""
-			}
-			inKey = !inKey
+
+		start := i
+		for i < len(data) && data[i] != '=' && data[i] != ',' && !isSpace(data[i]) {
+			i++
+		}
+		key := data[start:i]
+		if i >= len(data) || data[i] != '=' {
+			problems = append(problems, &ParseError{Column: start + 1, Msg: fmt.Sprintf("attribute %q without value", key)})
 			continue
 		}
-		if inKey {
-			key += string(data[token])
+		i++
+
+		var value string
+		if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+			quote := data[i]
+			quoteStart := i
+			i++
+			var b strings.Builder
+			closed := false
+			for i < len(data) {
+				c := data[i]
+				if c == '\\' && i+1 < len(data) && (data[i+1] == quote || data[i+1] == '\\') {
+					b.WriteByte(data[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if c == quote {
+					closed = true
+					break
+				}
+				b.WriteByte(c)
+			}
+			if !closed {
+				problems = append(problems, &ParseError{Column: quoteStart + 1, Msg: fmt.Sprintf("unterminated value of attribute %q", key)})
+				// Recover at the first comma, the title likely follows it
+				raw := data[quoteStart+1:]
+				if comma := strings.IndexByte(raw, ','); comma >= 0 {
+					attrs = append(attrs, M3UTvgTag{Tag: key, Value: raw[:comma]})
+					return attrs, quoteStart + 1 + comma, problems
+				}
+			}
+			value = b.String()
 		} else {
-			value += string(data[token])
+			start := i
+			for i < len(data) && !isSpace(data[i]) && data[i] != ',' {
+				i++
+			}
+			value = data[start:i]
 		}
+		if key == "" {
+			problems = append(problems, &ParseError{Column: start + 1, Msg: "attribute without name"})
+			continue
+		}
+		attrs = append(attrs, M3UTvgTag{Tag: key, Value: value})
 	}
+}
 
+func ExtractExtinfTags(data string) M3UExtinfTags {
+	tags, _, _ := parseAttrs(data, 0)
 	return tags
 }
 
+// String quotes the value with the escapes parseAttrs reads, backslashes
+// first.
 func (tag *M3UTvgTag) String() string {
-	return tag.Tag + "=\"" + tag.Value + "\""
+	value := strings.ReplaceAll(tag.Value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return tag.Tag + "=\"" + value + "\""
 }
 
 func (tags M3UExtinfTags) GetValue(tag string) string {
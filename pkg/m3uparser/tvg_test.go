@@ -26,3 +26,79 @@ func TestParseTvgTag(t *testing.T) {
 		t.Errorf("Unexpected tag value. Expected: %s, Got: %s", expectedTvgID, tags.GetValue("tvg-id"))
 	}
 }
+
+func TestParseExtinf(t *testing.T) {
+	value := `-1 tvg-id=foo tvg-name="Caf\"é, Olé" group-title='News, Sports',Café, the "best" channel`
+
+	extinf, problems := ParseExtinf(value)
+	if len(problems) != 0 {
+		t.Errorf("Unexpected problems: %v", problems)
+	}
+
+	if extinf.Duration != -1 {
+		t.Errorf("Unexpected duration. Expected: -1, Got: %f", extinf.Duration)
+	}
+
+	expectedTags := M3UExtinfTags{
+		{"tvg-id", "foo"},
+		{"tvg-name", "Caf\"é, Olé"},
+		{"group-title", "News, Sports"},
+	}
+	if len(extinf.Attrs) != len(expectedTags) {
+		t.Fatalf("Unexpected number of tags. Expected: %d, Got: %d", len(expectedTags), len(extinf.Attrs))
+	}
+	for i, tag := range expectedTags {
+		if extinf.Attrs[i] != tag {
+			t.Errorf("Unexpected tag. Expected: %v, Got: %v", tag, extinf.Attrs[i])
+		}
+	}
+
+	expectedTitle := `Café, the "best" channel`
+	if extinf.Title != expectedTitle {
+		t.Errorf("Unexpected title. Expected: %s, Got: %s", expectedTitle, extinf.Title)
+	}
+}
+
+func TestParseExtinf_Malformed(t *testing.T) {
+	extinf, problems := ParseExtinf(`abc tvg-id="foo,Channel 1`)
+	if len(problems) != 2 {
+		t.Fatalf("Unexpected number of problems. Expected: 2, Got: %d", len(problems))
+	}
+
+	if problems[0].Column != 1 || problems[1].Column != 12 {
+		t.Errorf("Unexpected columns. Got: %d, %d", problems[0].Column, problems[1].Column)
+	}
+
+	if extinf.Attrs.GetValue("tvg-id") != "foo" || extinf.Title != "Channel 1" {
+		t.Errorf("Unexpected recovery. Got: %v, %s", extinf.Attrs, extinf.Title)
+	}
+}
+
+func TestTvgTagString_RoundTrip(t *testing.T) {
+	tags := M3UExtinfTags{
+		{"tvg-name", `C:\dir\`},
+		{"tvg-id", `a "quoted" \id`},
+	}
+
+	value := "-1 " + tags[0].String() + " " + tags[1].String() + ",Channel 1"
+	expectedValue := `-1 tvg-name="C:\\dir\\" tvg-id="a \"quoted\" \\id",Channel 1`
+	if value != expectedValue {
+		t.Errorf("Unexpected attributes. Expected: %s, Got: %s", expectedValue, value)
+	}
+
+	extinf, problems := ParseExtinf(value)
+	if len(problems) != 0 {
+		t.Errorf("Unexpected problems: %v", problems)
+	}
+	if len(extinf.Attrs) != len(tags) {
+		t.Fatalf("Unexpected number of tags. Expected: %d, Got: %d", len(tags), len(extinf.Attrs))
+	}
+	for i, tag := range tags {
+		if extinf.Attrs[i] != tag {
+			t.Errorf("Unexpected tag. Expected: %v, Got: %v", tag, extinf.Attrs[i])
+		}
+	}
+	if extinf.Title != "Channel 1" {
+		t.Errorf("Unexpected title. Expected: Channel 1, Got: %s", extinf.Title)
+	}
+}
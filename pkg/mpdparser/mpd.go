@@ -4,8 +4,11 @@ package mpd
 import (
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io"
-	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/unki2aut/go-xsd-types"
 )
@@ -14,11 +17,11 @@ import (
 // https://www.brendanlong.com/the-structure-of-an-mpeg-dash-mpd.html
 // http://standards.iso.org/ittf/PubliclyAvailableStandards/MPEG-DASH_schema_files/DASH-MPD.xsd
 
-var emptyElementRE = regexp.MustCompile(`></[A-Za-z]+>`)
-
 // MPD represents root XML element.
 type MPD struct {
 	XMLNS                      *string       `xml:"xmlns,attr"`
+	XMLNSCenc                  *string       `xml:"xmlns:cenc,attr"`
+	ID                         *string       `xml:"id,attr"`
 	Type                       *string       `xml:"type,attr"`
 	MinimumUpdatePeriod        *xsd.Duration `xml:"minimumUpdatePeriod,attr"`
 	AvailabilityStartTime      *xsd.DateTime `xml:"availabilityStartTime,attr"`
@@ -29,8 +32,12 @@ type MPD struct {
 	TimeShiftBufferDepth       *xsd.Duration `xml:"timeShiftBufferDepth,attr"`
 	PublishTime                *xsd.DateTime `xml:"publishTime,attr"`
 	Profiles                   string        `xml:"profiles,attr"`
+	Attrs                      []xml.Attr    `xml:",any,attr"`
+	Elements                   []*Node       `xml:",any"`
 	BaseURL                    []*BaseURL    `xml:"BaseURL,omitempty"`
+	Location                   []string      `xml:"Location,omitempty"`
 	Period                     []*Period     `xml:"Period,omitempty"`
+	UTCTiming                  []*Descriptor `xml:"UTCTiming,omitempty"`
 }
 
 // Do not try to use encoding.TextMarshaler and encoding.TextUnmarshaler:
@@ -46,90 +53,415 @@ func (m *MPD) Encode() ([]byte, error) {
 		return nil, err
 	}
 
-	// hacks for self-closing tags
 	res := new(bytes.Buffer)
 	res.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
 	res.WriteByte('\n')
+	err = selfClose(res, x)
+	if err != nil {
+		return nil, err
+	}
+	res.WriteByte('\n')
+	return res.Bytes(), nil
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+// indexAttr is added by the decoder to every element, its position among the
+// element siblings. encoding/xml writes the fields of a type in declaration
+// order, so the unknown elements would be written before the known ones, the
+// index is used to write them back where they were.
+const indexAttr = "mpdparser:index"
+
+// element is an element of the XML generated by encoding/xml, read back to
+// restore the original order of its children.
+type element struct {
+	start   xml.StartElement
+	index   int // -1 if it wasn't decoded
+	content []any
+}
+
+// selfClose copies the XML generated by encoding/xml, which never writes
+// self-closing tags, writing empty elements as self-closing tags. Decoded
+// children are written in their original order.
+func selfClose(w *bytes.Buffer, r io.Reader) error {
+	d := xml.NewDecoder(r)
+	document := &element{index: -1}
+	stack := []*element{document}
 	for {
-		s, err := x.ReadString('\n')
-		if s != "" {
-			s = emptyElementRE.ReplaceAllString(s, `/>`)
-			res.WriteString(s)
-		}
+		t, err := d.RawToken()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := t.(type) {
+		case xml.StartElement:
+			child := newElement(t.Copy())
+			parent.content = append(parent.content, child)
+			stack = append(stack, child)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return fmt.Errorf("unexpected end element %s", rawName(t.Name).Local)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			parent.content = append(parent.content, xml.CopyToken(t))
 		}
 	}
-	res.WriteByte('\n')
-	return res.Bytes(), err
+	writeContent(w, document)
+	return nil
+}
+
+func newElement(start xml.StartElement) *element {
+	e := &element{start: start, index: -1}
+	attrs := start.Attr[:0]
+	for _, attr := range start.Attr {
+		if rawName(attr.Name).Local != indexAttr {
+			attrs = append(attrs, attr)
+			continue
+		}
+		if index, err := strconv.Atoi(attr.Value); err == nil {
+			e.index = index
+		}
+	}
+	e.start.Attr = attrs
+	return e
+}
+
+// sortChildren puts the child elements back in their original order, keeping
+// the character data around them, the indentation, in place. Elements that
+// weren't decoded stay after the element they follow.
+func sortChildren(e *element) {
+	var slots []int
+	var children []*element
+	var keys []int
+	key := -1
+	for i, item := range e.content {
+		child, ok := item.(*element)
+		if !ok {
+			continue
+		}
+		if child.index >= 0 {
+			key = child.index
+		}
+		slots = append(slots, i)
+		children = append(children, child)
+		keys = append(keys, key)
+	}
+
+	order := make([]int, len(children))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	for i, slot := range slots {
+		e.content[slot] = children[order[i]]
+	}
+}
+
+func writeContent(w *bytes.Buffer, e *element) {
+	sortChildren(e)
+	for _, item := range e.content {
+		switch t := item.(type) {
+		case *element:
+			writeStart(w, t.start, len(t.content) == 0)
+			if len(t.content) > 0 {
+				writeContent(w, t)
+				w.WriteString("</" + rawName(t.start.Name).Local + ">")
+			}
+		case xml.CharData:
+			textEscaper.WriteString(w, string(t))
+		case xml.Comment:
+			w.WriteString("<!--")
+			w.Write(t)
+			w.WriteString("-->")
+		case xml.ProcInst:
+			w.WriteString("<?" + t.Target + " ")
+			w.Write(t.Inst)
+			w.WriteString("?>")
+		case xml.Directive:
+			w.WriteString("<!")
+			w.Write(t)
+			w.WriteString(">")
+		}
+	}
+}
+
+func writeStart(w *bytes.Buffer, start xml.StartElement, empty bool) {
+	w.WriteString("<" + rawName(start.Name).Local)
+	for _, attr := range start.Attr {
+		w.WriteString(" " + rawName(attr.Name).Local + `="`)
+		xml.EscapeText(w, []byte(attr.Value))
+		w.WriteString(`"`)
+	}
+	if empty {
+		w.WriteString("/>")
+		return
+	}
+	w.WriteString(">")
 }
 
 // Decode parses MPD XML.
 func (m *MPD) Decode(b []byte) error {
-	return xml.Unmarshal(b, m)
+	return newDecoder(bytes.NewReader(b)).Decode(m)
+}
+
+// rawTokenReader returns tokens without translating name space prefixes, so
+// prefixed names such as cenc:pssh are matched and written back as they are.
+// Elements get their position among their siblings as an indexAttr attribute.
+type rawTokenReader struct {
+	d        *xml.Decoder
+	siblings []int // elements read at each open level
+}
+
+func (r *rawTokenReader) Token() (xml.Token, error) {
+	t, err := r.d.RawToken()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case xml.StartElement:
+		t.Name = rawName(t.Name)
+		attrs := make([]xml.Attr, len(t.Attr), len(t.Attr)+1)
+		for i, attr := range t.Attr {
+			attrs[i] = xml.Attr{Name: rawName(attr.Name), Value: attr.Value}
+		}
+		if level := len(r.siblings) - 1; level >= 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: indexAttr}, Value: strconv.Itoa(r.siblings[level])})
+			r.siblings[level]++
+		}
+		r.siblings = append(r.siblings, 0)
+		t.Attr = attrs
+		return t, nil
+	case xml.EndElement:
+		if len(r.siblings) > 0 {
+			r.siblings = r.siblings[:len(r.siblings)-1]
+		}
+		t.Name = rawName(t.Name)
+		return t, nil
+	}
+	return xml.CopyToken(t), nil
+}
+
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+func newDecoder(r io.Reader) *xml.Decoder {
+	return xml.NewTokenDecoder(&rawTokenReader{d: xml.NewDecoder(r)})
 }
 
 // Period represents XSD's PeriodType.
 type Period struct {
-	Start          *xsd.Duration    `xml:"start,attr"`
-	ID             *string          `xml:"id,attr"`
-	Duration       *xsd.Duration    `xml:"duration,attr"`
-	AdaptationSets []*AdaptationSet `xml:"AdaptationSet,omitempty"`
-	BaseURL        []*BaseURL       `xml:"BaseURL,omitempty"`
+	Start           *xsd.Duration    `xml:"start,attr"`
+	ID              *string          `xml:"id,attr"`
+	Duration        *xsd.Duration    `xml:"duration,attr"`
+	Attrs           []xml.Attr       `xml:",any,attr"`
+	Elements        []*Node          `xml:",any"`
+	BaseURL         []*BaseURL       `xml:"BaseURL,omitempty"`
+	SegmentBase     *SegmentBase     `xml:"SegmentBase,omitempty"`
+	SegmentList     *SegmentList     `xml:"SegmentList,omitempty"`
+	SegmentTemplate *SegmentTemplate `xml:"SegmentTemplate,omitempty"`
+	EventStreams    []*EventStream   `xml:"EventStream,omitempty"`
+	AdaptationSets  []*AdaptationSet `xml:"AdaptationSet,omitempty"`
 }
 
 // BaseURL represents XSD's BaseURLType.
 type BaseURL struct {
-	Value                    string  `xml:",chardata"`
-	ServiceLocation          *string `xml:"serviceLocation,attr"`
-	ByteRange                *string `xml:"byteRange,attr"`
-	AvailabilityTimeOffset   *uint64 `xml:"availabilityTimeOffset,attr"`
-	AvailabilityTimeComplete *bool   `xml:"availabilityTimeComplete,attr"`
+	Value                    string     `xml:",chardata"`
+	ServiceLocation          *string    `xml:"serviceLocation,attr"`
+	ByteRange                *string    `xml:"byteRange,attr"`
+	AvailabilityTimeOffset   *uint64    `xml:"availabilityTimeOffset,attr"`
+	AvailabilityTimeComplete *bool      `xml:"availabilityTimeComplete,attr"`
+	Attrs                    []xml.Attr `xml:",any,attr"`
 }
 
 // AdaptationSet represents XSD's AdaptationSetType.
 type AdaptationSet struct {
-	MimeType                string           `xml:"mimeType,attr"`
-	ContentType             *string          `xml:"contentType,attr"`
-	SegmentAlignment        ConditionalUint  `xml:"segmentAlignment,attr"`
-	SubsegmentAlignment     ConditionalUint  `xml:"subsegmentAlignment,attr"`
-	StartWithSAP            ConditionalUint  `xml:"startWithSAP,attr"`
-	SubsegmentStartsWithSAP ConditionalUint  `xml:"subsegmentStartsWithSAP,attr"`
-	BitstreamSwitching      *bool            `xml:"bitstreamSwitching,attr"`
-	Lang                    *string          `xml:"lang,attr"`
-	Par                     *string          `xml:"par,attr"`
-	Codecs                  *string          `xml:"codecs,attr"`
-	Role                    []*Descriptor    `xml:"Role,omitempty"`
-	BaseURL                 []*BaseURL       `xml:"BaseURL,omitempty"`
-	SegmentTemplate         *SegmentTemplate `xml:"SegmentTemplate,omitempty"`
-	ContentProtections      []Descriptor     `xml:"ContentProtection,omitempty"`
-	Representations         []Representation `xml:"Representation,omitempty"`
+	ID                        *string          `xml:"id,attr"`
+	Group                     *uint64          `xml:"group,attr"`
+	MimeType                  string           `xml:"mimeType,attr,omitempty"`
+	ContentType               *string          `xml:"contentType,attr"`
+	SegmentAlignment          ConditionalUint  `xml:"segmentAlignment,attr"`
+	SubsegmentAlignment       ConditionalUint  `xml:"subsegmentAlignment,attr"`
+	StartWithSAP              ConditionalUint  `xml:"startWithSAP,attr"`
+	SubsegmentStartsWithSAP   ConditionalUint  `xml:"subsegmentStartsWithSAP,attr"`
+	BitstreamSwitching        *bool            `xml:"bitstreamSwitching,attr"`
+	Lang                      *string          `xml:"lang,attr"`
+	Par                       *string          `xml:"par,attr"`
+	Codecs                    *string          `xml:"codecs,attr"`
+	Attrs                     []xml.Attr       `xml:",any,attr"`
+	Elements                  []*Node          `xml:",any"`
+	AudioChannelConfiguration []*Descriptor    `xml:"AudioChannelConfiguration,omitempty"`
+	ContentProtections        []Descriptor     `xml:"ContentProtection,omitempty"`
+	Labels                    []*Label         `xml:"Label,omitempty"`
+	Accessibility             []*Descriptor    `xml:"Accessibility,omitempty"`
+	Role                      []*Descriptor    `xml:"Role,omitempty"`
+	BaseURL                   []*BaseURL       `xml:"BaseURL,omitempty"`
+	SegmentBase               *SegmentBase     `xml:"SegmentBase,omitempty"`
+	SegmentList               *SegmentList     `xml:"SegmentList,omitempty"`
+	SegmentTemplate           *SegmentTemplate `xml:"SegmentTemplate,omitempty"`
+	Representations           []Representation `xml:"Representation,omitempty"`
 }
 
 // Representation represents XSD's RepresentationType.
 type Representation struct {
-	ID                 *string          `xml:"id,attr"`
-	Width              *uint64          `xml:"width,attr"`
-	Height             *uint64          `xml:"height,attr"`
-	FrameRate          *string          `xml:"frameRate,attr"`
-	Bandwidth          *uint64          `xml:"bandwidth,attr"`
-	AudioSamplingRate  *string          `xml:"audioSamplingRate,attr"`
-	Codecs             *string          `xml:"codecs,attr"`
-	SAR                *string          `xml:"sar,attr"`
-	ScanType           *string          `xml:"scanType,attr"`
-	ContentProtections []Descriptor     `xml:"ContentProtection,omitempty"`
-	SegmentTemplate    *SegmentTemplate `xml:"SegmentTemplate,omitempty"`
-	BaseURL            []*BaseURL       `xml:"BaseURL,omitempty"`
-}
-
-// Descriptor represents XSD's DescriptorType.
+	ID                        *string          `xml:"id,attr"`
+	MimeType                  *string          `xml:"mimeType,attr"`
+	Width                     *uint64          `xml:"width,attr"`
+	Height                    *uint64          `xml:"height,attr"`
+	FrameRate                 *string          `xml:"frameRate,attr"`
+	Bandwidth                 *uint64          `xml:"bandwidth,attr"`
+	AudioSamplingRate         *string          `xml:"audioSamplingRate,attr"`
+	Codecs                    *string          `xml:"codecs,attr"`
+	SAR                       *string          `xml:"sar,attr"`
+	ScanType                  *string          `xml:"scanType,attr"`
+	Attrs                     []xml.Attr       `xml:",any,attr"`
+	Elements                  []*Node          `xml:",any"`
+	AudioChannelConfiguration []*Descriptor    `xml:"AudioChannelConfiguration,omitempty"`
+	ContentProtections        []Descriptor     `xml:"ContentProtection,omitempty"`
+	Labels                    []*Label         `xml:"Label,omitempty"`
+	BaseURL                   []*BaseURL       `xml:"BaseURL,omitempty"`
+	SegmentBase               *SegmentBase     `xml:"SegmentBase,omitempty"`
+	SegmentList               *SegmentList     `xml:"SegmentList,omitempty"`
+	SegmentTemplate           *SegmentTemplate `xml:"SegmentTemplate,omitempty"`
+}
+
+// Descriptor represents XSD's DescriptorType, Pssh is only used by
+// ContentProtection.
 type Descriptor struct {
-	SchemeIDURI      *string `xml:"schemeIdUri,attr"`
-	Value            *string `xml:"value,attr"`
-	CencDefaultKeyId *string `xml:"default_KID,attr,omitempty"`
+	SchemeIDURI      *string    `xml:"schemeIdUri,attr"`
+	Value            *string    `xml:"value,attr"`
+	ID               *string    `xml:"id,attr"`
+	CencDefaultKeyId *string    `xml:"cenc:default_KID,attr,omitempty"`
+	Attrs            []xml.Attr `xml:",any,attr"`
+	Elements         []*Node    `xml:",any"`
+	Pssh             []*Pssh    `xml:"cenc:pssh,omitempty"`
+}
+
+// Pssh represents a base64 encoded cenc:pssh box.
+type Pssh struct {
+	Value string     `xml:",chardata"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// Label represents XSD's LabelType.
+type Label struct {
+	ID    *uint64    `xml:"id,attr"`
+	Lang  *string    `xml:"lang,attr"`
+	Value string     `xml:",chardata"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// EventStream represents XSD's EventStreamType.
+type EventStream struct {
+	SchemeIDURI            *string    `xml:"schemeIdUri,attr"`
+	Value                  *string    `xml:"value,attr"`
+	Timescale              *uint64    `xml:"timescale,attr"`
+	PresentationTimeOffset *uint64    `xml:"presentationTimeOffset,attr"`
+	Attrs                  []xml.Attr `xml:",any,attr"`
+	Elements               []*Node    `xml:",any"`
+	Events                 []*Event   `xml:"Event,omitempty"`
+}
+
+// Event represents XSD's EventType, its content is kept as is.
+type Event struct {
+	PresentationTime *uint64    `xml:"presentationTime,attr"`
+	Duration         *uint64    `xml:"duration,attr"`
+	ID               *uint64    `xml:"id,attr"`
+	MessageData      *string    `xml:"messageData,attr"`
+	Value            string     `xml:",chardata"`
+	Attrs            []xml.Attr `xml:",any,attr"`
+	Elements         []*Node    `xml:",any"`
+}
+
+// Node is an element the model doesn't know about, such as a vendor extension
+// or the SCTE-35 signal carried by an Event. Unknown elements and attributes
+// are kept at every level so they are written back by Encode, in their
+// original position.
+type Node struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Value    string     `xml:",chardata"`
+	Elements []*Node    `xml:",any"`
+}
+
+func (n *Node) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type node Node
+	if err := d.DecodeElement((*node)(n), &start); err != nil {
+		return err
+	}
+	// Names are raw, only the default name space is translated by the decoder
+	n.XMLName.Space = ""
+	n.Value = trimIndent(n.Value)
+	return nil
+}
+
+func (e *Event) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type event Event
+	if err := d.DecodeElement((*event)(e), &start); err != nil {
+		return err
+	}
+	e.Value = trimIndent(e.Value)
+	return nil
+}
+
+// trimIndent drops character data made only of white space, the indentation
+// around child elements, so it doesn't grow every time a manifest is encoded.
+func trimIndent(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return ""
+	}
+	return value
+}
+
+// URL represents XSD's URLType.
+type URL struct {
+	SourceURL *string    `xml:"sourceURL,attr"`
+	Range     *string    `xml:"range,attr"`
+	Attrs     []xml.Attr `xml:",any,attr"`
+	Elements  []*Node    `xml:",any"`
+}
+
+// SegmentBase represents XSD's SegmentBaseType.
+type SegmentBase struct {
+	Timescale              *uint64    `xml:"timescale,attr"`
+	PresentationTimeOffset *uint64    `xml:"presentationTimeOffset,attr"`
+	IndexRange             *string    `xml:"indexRange,attr"`
+	IndexRangeExact        *bool      `xml:"indexRangeExact,attr"`
+	Attrs                  []xml.Attr `xml:",any,attr"`
+	Elements               []*Node    `xml:",any"`
+	Initialization         *URL       `xml:"Initialization,omitempty"`
+	RepresentationIndex    *URL       `xml:"RepresentationIndex,omitempty"`
+}
+
+// SegmentList represents XSD's SegmentListType.
+type SegmentList struct {
+	Duration               *uint64          `xml:"duration,attr"`
+	Timescale              *uint64          `xml:"timescale,attr"`
+	StartNumber            *uint64          `xml:"startNumber,attr"`
+	PresentationTimeOffset *uint64          `xml:"presentationTimeOffset,attr"`
+	Attrs                  []xml.Attr       `xml:",any,attr"`
+	Elements               []*Node          `xml:",any"`
+	Initialization         *URL             `xml:"Initialization,omitempty"`
+	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline,omitempty"`
+	SegmentURLs            []*SegmentURL    `xml:"SegmentURL,omitempty"`
+}
+
+// SegmentURL represents XSD's SegmentURLType.
+type SegmentURL struct {
+	Media      *string    `xml:"media,attr"`
+	MediaRange *string    `xml:"mediaRange,attr"`
+	Index      *string    `xml:"index,attr"`
+	IndexRange *string    `xml:"indexRange,attr"`
+	Attrs      []xml.Attr `xml:",any,attr"`
+	Elements   []*Node    `xml:",any"`
 }
 
 // SegmentTemplate represents XSD's SegmentTemplateType.
@@ -137,27 +469,82 @@ type SegmentTemplate struct {
 	Duration               *uint64          `xml:"duration,attr"`
 	Timescale              *uint64          `xml:"timescale,attr"`
 	Media                  *string          `xml:"media,attr"`
+	Index                  *string          `xml:"index,attr"`
 	Initialization         *string          `xml:"initialization,attr"`
+	BitstreamSwitching     *string          `xml:"bitstreamSwitching,attr"`
 	StartNumber            *uint64          `xml:"startNumber,attr"`
+	EndNumber              *uint64          `xml:"endNumber,attr"`
 	PresentationTimeOffset *uint64          `xml:"presentationTimeOffset,attr"`
+	Attrs                  []xml.Attr       `xml:",any,attr"`
+	Elements               []*Node          `xml:",any"`
 	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline,omitempty"`
 }
 
+// inherit returns the template with the attributes it doesn't set taken from
+// parent, the template of the upper level.
+func (t *SegmentTemplate) inherit(parent *SegmentTemplate) *SegmentTemplate {
+	if t == nil {
+		return parent
+	}
+	if parent == nil {
+		return t
+	}
+	merged := *t
+	inheritValue(&merged.Duration, parent.Duration)
+	inheritValue(&merged.Timescale, parent.Timescale)
+	inheritValue(&merged.Media, parent.Media)
+	inheritValue(&merged.Index, parent.Index)
+	inheritValue(&merged.Initialization, parent.Initialization)
+	inheritValue(&merged.BitstreamSwitching, parent.BitstreamSwitching)
+	inheritValue(&merged.StartNumber, parent.StartNumber)
+	inheritValue(&merged.EndNumber, parent.EndNumber)
+	inheritValue(&merged.PresentationTimeOffset, parent.PresentationTimeOffset)
+	inheritValue(&merged.SegmentTimeline, parent.SegmentTimeline)
+	return &merged
+}
+
+func inheritValue[T any](value **T, parent *T) {
+	if *value == nil {
+		*value = parent
+	}
+}
+
+// EffectiveSegmentTemplate returns the SegmentTemplate in effect for a
+// Representation, combining the Period, AdaptationSet and Representation
+// levels. It returns nil if none of them has one.
+func EffectiveSegmentTemplate(period *Period, adaptationSet *AdaptationSet, representation *Representation) *SegmentTemplate {
+	var template *SegmentTemplate
+	if period != nil {
+		template = period.SegmentTemplate
+	}
+	if adaptationSet != nil {
+		template = adaptationSet.SegmentTemplate.inherit(template)
+	}
+	if representation != nil {
+		template = representation.SegmentTemplate.inherit(template)
+	}
+	return template
+}
+
 // SegmentTimeline represents XSD's SegmentTimelineType.
 type SegmentTimeline struct {
-	S []*SegmentTimelineS `xml:"S"`
+	Attrs    []xml.Attr          `xml:",any,attr"`
+	Elements []*Node             `xml:",any"`
+	S        []*SegmentTimelineS `xml:"S"`
 }
 
 // SegmentTimelineS represents XSD's SegmentTimelineType's inner S elements.
 type SegmentTimelineS struct {
-	T *uint64 `xml:"t,attr"`
-	D uint64  `xml:"d,attr"`
-	R *int64  `xml:"r,attr"`
+	T        *uint64    `xml:"t,attr"`
+	D        uint64     `xml:"d,attr"`
+	R        *int64     `xml:"r,attr"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Elements []*Node    `xml:",any"`
 }
 
 func DecodeFromReader(buf io.Reader) (*MPD, error) {
 	m := new(MPD)
-	d := xml.NewDecoder(buf)
+	d := newDecoder(buf)
 	err := d.Decode(m)
 	if err != nil {
 		return nil, err
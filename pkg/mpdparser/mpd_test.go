@@ -0,0 +1,100 @@
+package mpd
+
+import (
+	"strings"
+	"testing"
+)
+
+// roundTripMPD is written the way Encode writes it, so it must come back
+// unchanged.
+const roundTripMPD = `<?xml version="1.0" encoding="utf-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:cenc="urn:mpeg:cenc:2013" type="dynamic" minimumUpdatePeriod="PT2S" availabilityStartTime="2024-01-01T00:00:00" profiles="urn:mpeg:dash:profile:isoff-live:2011" xmlns:scte35="http://www.scte.org/schemas/35/2016" xmlns:dolby="http://www.dolby.com/ns/online/DASH">
+  <ProgramInformation moreInformationURL="https://example.com">
+    <Title>Channel 1</Title>
+  </ProgramInformation>
+  <BaseURL>https://origin.example.com/live/</BaseURL>
+  <Location>https://origin.example.com/live/manifest.mpd</Location>
+  <Period start="PT0S" id="1">
+    <EventStream schemeIdUri="urn:scte:scte35:2014:xml+bin" timescale="90000">
+      <Event presentationTime="900000" duration="2700000" id="1">
+        <scte35:Signal>
+          <scte35:Binary>/DAlAAAAAAAAAP/wFAUAAAABf+/+AAAAAH4AKTLgAAEAAAAAjDxbRQ==</scte35:Binary>
+        </scte35:Signal>
+      </Event>
+    </EventStream>
+    <AdaptationSet id="1" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1" dolby:flag="yes">
+      <ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc" cenc:default_KID="10000000-1000-1000-1000-100000000001"/>
+      <ContentProtection schemeIdUri="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed">
+        <cenc:pssh>AAAAW3Bzc2gAAAAA</cenc:pssh>
+      </ContentProtection>
+      <EssentialProperty schemeIdUri="urn:mpeg:dash:video:trick:2014" value="1"/>
+      <SupplementalProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="16"/>
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>
+      <SegmentTemplate timescale="90000" media="$RepresentationID$/$Number$.m4s" initialization="$RepresentationID$/init.mp4" startNumber="1">
+        <SegmentTimeline>
+          <S t="0" d="180000" r="9"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="v1" width="1280" height="720" frameRate="25" bandwidth="2500000" codecs="avc1.64001f" sar="1:1">
+        <SupplementalProperty schemeIdUri="tag:dolby.com,2018:dash:EC3_ExtensionType:2018" value="JOC"/>
+      </Representation>
+      <Representation id="v2" width="1920" height="1080" frameRate="25" bandwidth="5000000" codecs="avc1.640028"/>
+    </AdaptationSet>
+    <SupplementalProperty schemeIdUri="urn:example:period" value="after"/>
+  </Period>
+  <UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-iso:2014" value="https://time.example.com/"/>
+</MPD>`
+
+func TestRoundTrip(t *testing.T) {
+	m, err := DecodeFromReader(strings.NewReader(roundTripMPD))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the manifest: %v", err)
+	}
+	got, err := m.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error encoding the manifest: %v", err)
+	}
+	if string(got) != roundTripMPD+"\n" {
+		t.Errorf("Unexpected manifest. Expected:\n%s\nGot:\n%s", roundTripMPD, got)
+	}
+}
+
+func TestRoundTrip_Modified(t *testing.T) {
+	m, err := DecodeFromReader(strings.NewReader(roundTripMPD))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the manifest: %v", err)
+	}
+	m.BaseURL = nil
+	m.Location = nil
+	period := m.Period[0]
+	period.AdaptationSets[0].Representations = period.AdaptationSets[0].Representations[1:]
+	m.Period = append(m.Period, &Period{ID: period.ID})
+
+	got, err := m.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error encoding the manifest: %v", err)
+	}
+	// Elements keep their order, the added ones follow the element before them
+	expected := []string{
+		"<ProgramInformation ",
+		`<Representation id="v2"`,
+		`<SupplementalProperty schemeIdUri="urn:example:period" value="after"/>`,
+		"</Period>",
+		`<Period id="1"/>`,
+		"<UTCTiming ",
+	}
+	last := -1
+	for _, s := range expected {
+		i := strings.Index(string(got), s)
+		if i <= last {
+			t.Errorf("Unexpected position of %s in:\n%s", s, got)
+			continue
+		}
+		last = i
+	}
+	for _, s := range []string{"<BaseURL>", "<Location>", `id="v1"`, indexAttr} {
+		if strings.Contains(string(got), s) {
+			t.Errorf("Unexpected %s in:\n%s", s, got)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package mpd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unki2aut/go-xsd-types"
+)
+
+// defaultLiveWindow is how far back segments are listed for dynamic
+// presentations without a timeShiftBufferDepth.
+const defaultLiveWindow = time.Minute
+
+// Segment is a segment of a Representation. Time and Duration are in the
+// timescale of the Representation, Start is relative to the Period start.
+type Segment struct {
+	URL       string
+	Range     string
+	Number    uint64
+	Time      uint64
+	Duration  uint64
+	Timescale uint64
+	Start     time.Duration
+}
+
+// Length returns the duration of the segment.
+func (s *Segment) Length() time.Duration {
+	return scaleDuration(s.Duration, s.Timescale)
+}
+
+// SegmentIndex holds the segments of a Representation, Initialization is nil
+// if the Representation doesn't have an initialization segment.
+type SegmentIndex struct {
+	Initialization *Segment
+	Segments       []*Segment
+}
+
+// window is the presentation time, relative to the Period start, in which
+// segments are listed. Segments must end after from and, for live
+// presentations, no later than to. Static presentations list every segment
+// starting before to.
+type window struct {
+	from    time.Duration
+	to      time.Duration
+	bounded bool
+	live    bool
+}
+
+func (w window) contains(start, length time.Duration) bool {
+	end := start + length
+	if end <= w.from || !w.bounded {
+		return end > w.from
+	}
+	if w.live {
+		return end <= w.to
+	}
+	return start < w.to
+}
+
+// Segments expands the segments of a Representation of period. URLs are
+// resolved against the manifest URL and the BaseURLs in effect. For dynamic
+// presentations only the segments available at now and still within the time
+// shift buffer are listed.
+func (m *MPD) Segments(manifest *url.URL, period *Period, representation *Representation, now time.Time) (*SegmentIndex, error) {
+	adaptationSet := findAdaptationSet(period, representation)
+	if adaptationSet == nil {
+		return nil, errors.New("representation not found in period")
+	}
+
+	base := manifest
+	for _, baseURLs := range [][]*BaseURL{m.BaseURL, period.BaseURL, adaptationSet.BaseURL, representation.BaseURL} {
+		var err error
+		base, err = resolveBaseURL(base, baseURLs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := m.window(period, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if template := EffectiveSegmentTemplate(period, adaptationSet, representation); template != nil {
+		return expandSegmentTemplate(template, representation, base, w)
+	}
+
+	if list := firstNonNil(representation.SegmentList, adaptationSet.SegmentList, period.SegmentList); list != nil {
+		return expandSegmentList(list, base, w)
+	}
+
+	index := &SegmentIndex{}
+	segmentBase := firstNonNil(representation.SegmentBase, adaptationSet.SegmentBase, period.SegmentBase)
+	if segmentBase != nil && segmentBase.Initialization != nil {
+		init, err := urlSegment(base, segmentBase.Initialization)
+		if err != nil {
+			return nil, err
+		}
+		index.Initialization = init
+	}
+	_, duration := m.periodTiming(period)
+	index.Segments = []*Segment{{
+		URL:       base.String(),
+		Number:    1,
+		Duration:  uint64(duration / time.Millisecond),
+		Timescale: 1000,
+	}}
+	return index, nil
+}
+
+func findAdaptationSet(period *Period, representation *Representation) *AdaptationSet {
+	for _, adaptationSet := range period.AdaptationSets {
+		for k := range adaptationSet.Representations {
+			if &adaptationSet.Representations[k] == representation {
+				return adaptationSet
+			}
+		}
+	}
+	return nil
+}
+
+func firstNonNil[T any](values ...*T) *T {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// resolveBaseURL applies the first BaseURL of a level to the parent's base.
+func resolveBaseURL(parent *url.URL, baseURLs []*BaseURL) (*url.URL, error) {
+	if len(baseURLs) == 0 {
+		return parent, nil
+	}
+	ref, err := url.Parse(strings.TrimSpace(baseURLs[0].Value))
+	if err != nil {
+		return nil, err
+	}
+	return parent.ResolveReference(ref), nil
+}
+
+// periodTiming returns the start and the duration of a Period, following the
+// rules used when they are not set explicitly. The duration is 0 if unknown.
+func (m *MPD) periodTiming(period *Period) (time.Duration, time.Duration) {
+	var start, previousEnd time.Duration
+	for i, p := range m.Period {
+		start = previousEnd
+		if p.Start != nil {
+			start = xsdDuration(p.Start)
+		}
+
+		var duration time.Duration
+		switch {
+		case p.Duration != nil:
+			duration = xsdDuration(p.Duration)
+		case i+1 < len(m.Period) && m.Period[i+1].Start != nil:
+			duration = xsdDuration(m.Period[i+1].Start) - start
+		case i+1 == len(m.Period) && m.MediaPresentationDuration != nil:
+			duration = xsdDuration(m.MediaPresentationDuration) - start
+		}
+
+		if p == period {
+			return start, duration
+		}
+		previousEnd = start + duration
+	}
+	return 0, 0
+}
+
+// window returns the time window in which segments of period are listed.
+func (m *MPD) window(period *Period, now time.Time) (window, error) {
+	start, duration := m.periodTiming(period)
+	w := window{to: duration, bounded: duration > 0}
+
+	if m.Type == nil || *m.Type != "dynamic" {
+		return w, nil
+	}
+
+	if m.AvailabilityStartTime == nil {
+		return window{}, errors.New("dynamic presentation without availabilityStartTime")
+	}
+
+	w.live = true
+	elapsed := now.Sub(time.Time(*m.AvailabilityStartTime)) - start
+	if !w.bounded || elapsed < w.to {
+		w.to = elapsed
+		w.bounded = true
+	}
+
+	depth := defaultLiveWindow
+	if m.TimeShiftBufferDepth != nil {
+		depth = xsdDuration(m.TimeShiftBufferDepth)
+	}
+	w.from = elapsed - depth
+	return w, nil
+}
+
+func expandSegmentTemplate(template *SegmentTemplate, representation *Representation, base *url.URL, w window) (*SegmentIndex, error) {
+	timescale := valueOr(template.Timescale, 1)
+	offset := valueOr(template.PresentationTimeOffset, 0)
+	number := valueOr(template.StartNumber, 1)
+	id := valueOr(representation.ID, "")
+	bandwidth := valueOr(representation.Bandwidth, 0)
+
+	index := &SegmentIndex{}
+	if template.Initialization != nil {
+		uri, err := resolveSegmentURL(base, ExpandTemplate(*template.Initialization, id, 0, bandwidth, 0))
+		if err != nil {
+			return nil, err
+		}
+		index.Initialization = &Segment{URL: uri, Timescale: timescale}
+	}
+
+	if template.Media == nil {
+		return nil, errors.New("segment template without media")
+	}
+
+	add := func(number, t, duration uint64) error {
+		uri, err := resolveSegmentURL(base, ExpandTemplate(*template.Media, id, number, bandwidth, t))
+		if err != nil {
+			return err
+		}
+		index.Segments = append(index.Segments, &Segment{
+			URL:       uri,
+			Number:    number,
+			Time:      t,
+			Duration:  duration,
+			Timescale: timescale,
+			Start:     scaleDuration(t, timescale) - scaleDuration(offset, timescale),
+		})
+		return nil
+	}
+
+	if template.SegmentTimeline != nil {
+		err := expandTimeline(template.SegmentTimeline, number, template.EndNumber, timescale, offset, w, add)
+		if err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+
+	if template.Duration == nil || *template.Duration == 0 {
+		return nil, errors.New("segment template without duration or timeline")
+	}
+	duration := *template.Duration
+	length := scaleDuration(duration, timescale)
+
+	if !w.bounded {
+		return nil, errors.New("unknown period duration")
+	}
+
+	first := uint64(0)
+	if w.from > 0 {
+		first = uint64(w.from / length)
+	}
+	// Live presentations only list complete segments, the last segment of a
+	// static presentation may be shorter.
+	last := uint64(0)
+	if w.to > 0 {
+		last = uint64(w.to / length)
+		if !w.live && w.to%length != 0 {
+			last++
+		}
+	}
+	if template.EndNumber != nil && *template.EndNumber >= number && last > *template.EndNumber-number+1 {
+		last = *template.EndNumber - number + 1
+	}
+
+	for k := first; k < last; k++ {
+		if err := add(number+k, offset+k*duration, duration); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// expandTimeline calls add for each segment of a SegmentTimeline within w.
+func expandTimeline(timeline *SegmentTimeline, number uint64, endNumber *uint64, timescale, offset uint64, w window, add func(number, t, duration uint64) error) error {
+	t := uint64(0)
+	for i, s := range timeline.S {
+		if s.T != nil {
+			t = *s.T
+		}
+		if s.D == 0 {
+			return errors.New("segment timeline entry without duration")
+		}
+
+		repeat := int64(0)
+		if s.R != nil {
+			repeat = *s.R
+		}
+		if repeat < 0 {
+			// Repeat until the next entry or the end of the window
+			var end uint64
+			switch {
+			case i+1 < len(timeline.S) && timeline.S[i+1].T != nil:
+				end = *timeline.S[i+1].T
+			case w.bounded:
+				end = offset + scaleUnits(w.to, timescale)
+			default:
+				return errors.New("open ended segment timeline in unbounded period")
+			}
+			repeat = -1
+			if end > t {
+				repeat = int64((end-t+s.D-1)/s.D) - 1
+			}
+		}
+
+		for ; repeat >= 0; repeat-- {
+			if endNumber != nil && number > *endNumber {
+				return nil
+			}
+			start := scaleDuration(t, timescale) - scaleDuration(offset, timescale)
+			if w.bounded && start >= w.to {
+				return nil
+			}
+			if w.contains(start, scaleDuration(s.D, timescale)) {
+				if err := add(number, t, s.D); err != nil {
+					return err
+				}
+			}
+			number++
+			t += s.D
+		}
+	}
+	return nil
+}
+
+func expandSegmentList(list *SegmentList, base *url.URL, w window) (*SegmentIndex, error) {
+	timescale := valueOr(list.Timescale, 1)
+	offset := valueOr(list.PresentationTimeOffset, 0)
+	number := valueOr(list.StartNumber, 1)
+
+	index := &SegmentIndex{}
+	if list.Initialization != nil {
+		init, err := urlSegment(base, list.Initialization)
+		if err != nil {
+			return nil, err
+		}
+		init.Timescale = timescale
+		index.Initialization = init
+	}
+
+	add := func(n, t, duration uint64) error {
+		k := n - number
+		if k >= uint64(len(list.SegmentURLs)) {
+			return nil
+		}
+		segmentURL := list.SegmentURLs[k]
+		uri, err := resolveSegmentURL(base, valueOr(segmentURL.Media, ""))
+		if err != nil {
+			return err
+		}
+		index.Segments = append(index.Segments, &Segment{
+			URL:       uri,
+			Range:     valueOr(segmentURL.MediaRange, ""),
+			Number:    n,
+			Time:      t,
+			Duration:  duration,
+			Timescale: timescale,
+			Start:     scaleDuration(t, timescale) - scaleDuration(offset, timescale),
+		})
+		return nil
+	}
+
+	if list.SegmentTimeline != nil {
+		if err := expandTimeline(list.SegmentTimeline, number, nil, timescale, offset, w, add); err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+
+	duration := valueOr(list.Duration, 0)
+	for k := range list.SegmentURLs {
+		t := offset + uint64(k)*duration
+		start := scaleDuration(t, timescale) - scaleDuration(offset, timescale)
+		if duration > 0 && !w.contains(start, scaleDuration(duration, timescale)) {
+			continue
+		}
+		if err := add(number+uint64(k), t, duration); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// urlSegment returns the segment referenced by an Initialization or
+// RepresentationIndex element, the BaseURL itself if it has no sourceURL.
+func urlSegment(base *url.URL, u *URL) (*Segment, error) {
+	uri, err := resolveSegmentURL(base, valueOr(u.SourceURL, ""))
+	if err != nil {
+		return nil, err
+	}
+	return &Segment{URL: uri, Range: valueOr(u.Range, "")}, nil
+}
+
+func resolveSegmentURL(base *url.URL, ref string) (string, error) {
+	if ref == "" {
+		return base.String(), nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+// ExpandTemplate substitutes the identifiers of a SegmentTemplate URL. Width
+// formatting, such as $Number%05d$, is supported for the numeric identifiers.
+func ExpandTemplate(template, representationID string, number, bandwidth, t uint64) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '$')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start+1:], '$')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+
+		b.WriteString(template[:start])
+		b.WriteString(expandIdentifier(template[start+1:end], representationID, number, bandwidth, t))
+		template = template[end+1:]
+	}
+	b.WriteString(template)
+	return b.String()
+}
+
+func expandIdentifier(identifier, representationID string, number, bandwidth, t uint64) string {
+	if identifier == "" {
+		return "$"
+	}
+
+	name, format, _ := strings.Cut(identifier, "%")
+	var value uint64
+	switch name {
+	case "RepresentationID":
+		if format == "" {
+			return representationID
+		}
+		return "$" + identifier + "$"
+	case "Number":
+		value = number
+	case "Bandwidth":
+		value = bandwidth
+	case "Time":
+		value = t
+	default:
+		return "$" + identifier + "$"
+	}
+
+	if format == "" {
+		return strconv.FormatUint(value, 10)
+	}
+	width, err := strconv.Atoi(strings.TrimSuffix(format, "d"))
+	if err != nil || !strings.HasPrefix(format, "0") || !strings.HasSuffix(format, "d") {
+		return "$" + identifier + "$"
+	}
+	return fmt.Sprintf("%0*d", width, value)
+}
+
+func valueOr[T any](value *T, fallback T) T {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+func xsdDuration(d *xsd.Duration) time.Duration {
+	n, err := d.ToNanoseconds()
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n)
+}
+
+// scaleDuration converts a value in timescale units to a duration, without
+// overflowing for large media times.
+func scaleDuration(value, timescale uint64) time.Duration {
+	if timescale == 0 {
+		timescale = 1
+	}
+	seconds := value / timescale
+	rest := value % timescale
+	return time.Duration(seconds)*time.Second + time.Duration(rest*uint64(time.Second)/timescale)
+}
+
+// scaleUnits converts a duration to timescale units.
+func scaleUnits(d time.Duration, timescale uint64) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	seconds := uint64(d / time.Second)
+	rest := uint64(d % time.Second)
+	return seconds*timescale + rest*timescale/uint64(time.Second)
+}
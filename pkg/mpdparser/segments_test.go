@@ -0,0 +1,115 @@
+package mpd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"$RepresentationID$/seg-$Number%05d$.m4s", "video-1/seg-00042.m4s"},
+		{"seg-$Number$.m4s", "seg-42.m4s"},
+		{"seg-$Time$.m4s", "seg-90000.m4s"},
+		{"$Bandwidth%08d$.m4s", "00500000.m4s"},
+		{"price$$-$Number$$$.m4s", "price$-42$.m4s"},
+		{"$$Number$$.m4s", "$Number$.m4s"},
+		{"$Unknown$-$Number$.m4s", "$Unknown$-42.m4s"},
+		{"$Number%5x$.m4s", "$Number%5x$.m4s"},
+		{"seg-$Number.m4s", "seg-$Number.m4s"},
+	}
+
+	for _, tt := range tests {
+		got := ExpandTemplate(tt.template, "video-1", 42, 500000, 90000)
+		if got != tt.expected {
+			t.Errorf("Unexpected expansion of %s. Expected: %s, Got: %s", tt.template, tt.expected, got)
+		}
+	}
+}
+
+func decodeSegments(t *testing.T, manifest string, now time.Time) *SegmentIndex {
+	t.Helper()
+
+	m, err := DecodeFromReader(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the manifest: %v", err)
+	}
+	uri, _ := url.Parse("https://example.com/live/manifest.mpd")
+	period := m.Period[0]
+	index, err := m.Segments(uri, period, &period.AdaptationSets[0].Representations[0], now)
+	if err != nil {
+		t.Fatalf("Unexpected error expanding the segments: %v", err)
+	}
+	return index
+}
+
+func TestSegments_TimelineRepeatToEnd(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT10S">
+  <Period id="1">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="1000" initialization="init-$RepresentationID$.mp4" media="seg-$Time$.m4s">
+        <SegmentTimeline>
+          <S t="0" d="2000" r="-1"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	index := decodeSegments(t, manifest, time.Time{})
+
+	if index.Initialization == nil || index.Initialization.URL != "https://example.com/live/init-v1.mp4" {
+		t.Errorf("Unexpected initialization segment: %v", index.Initialization)
+	}
+	if len(index.Segments) != 5 {
+		t.Fatalf("Unexpected number of segments. Expected: 5, Got: %d", len(index.Segments))
+	}
+	for i, segment := range index.Segments {
+		expectedTime := uint64(i) * 2000
+		expectedURL := fmt.Sprintf("https://example.com/live/seg-%d.m4s", expectedTime)
+		if segment.Time != expectedTime || segment.URL != expectedURL || segment.Number != uint64(i)+1 {
+			t.Errorf("Unexpected segment %d. Expected: %d %s, Got: %d %d %s", i, expectedTime, expectedURL, segment.Number, segment.Time, segment.URL)
+		}
+		if segment.Length() != 2*time.Second {
+			t.Errorf("Unexpected length of segment %d. Expected: 2s, Got: %s", i, segment.Length())
+		}
+	}
+}
+
+func TestSegments_LiveWindow(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic" availabilityStartTime="2024-01-01T00:00:00Z" timeShiftBufferDepth="PT10S">
+  <Period id="1" start="PT0S">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="1" duration="2" startNumber="1" media="$RepresentationID$/$Number%05d$.m4s"/>
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	// 61s after the start, 30 segments are complete and the ones ending in
+	// the last 10 seconds are still in the time shift buffer
+	now := time.Date(2024, 1, 1, 0, 1, 1, 0, time.UTC)
+	index := decodeSegments(t, manifest, now)
+
+	if len(index.Segments) != 5 {
+		t.Fatalf("Unexpected number of segments. Expected: 5, Got: %d", len(index.Segments))
+	}
+	for i, segment := range index.Segments {
+		expectedNumber := uint64(26 + i)
+		expectedURL := fmt.Sprintf("https://example.com/live/v1/%05d.m4s", expectedNumber)
+		if segment.Number != expectedNumber || segment.URL != expectedURL {
+			t.Errorf("Unexpected segment %d. Expected: %d %s, Got: %d %s", i, expectedNumber, expectedURL, segment.Number, segment.URL)
+		}
+		if expectedStart := time.Duration(expectedNumber-1) * 2 * time.Second; segment.Start != expectedStart {
+			t.Errorf("Unexpected start of segment %d. Expected: %s, Got: %s", i, expectedStart, segment.Start)
+		}
+	}
+}
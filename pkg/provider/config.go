@@ -13,6 +13,12 @@ type OverrideEntry struct {
 	HttpProxy        string            `json:"http_proxy,omitempty"`
 	ForceKodiHeaders bool              `json:"kodi,omitempty"`
 	DisableRemap     bool              `json:"disable_remap,omitempty"`
+	MaxResolution    string            `json:"max_resolution,omitempty"`
+	MaxBandwidth     int64             `json:"max_bandwidth,omitempty"`
+	Codecs           []string          `json:"codecs,omitempty"`
+	SingleVariant    bool              `json:"single_variant,omitempty"`
+	Languages        []string          `json:"languages,omitempty"`
+	OnlyLanguages    bool              `json:"only_languages,omitempty"`
 }
 
 type ProviderConfig struct {
@@ -2,6 +2,10 @@ package provider
 
 import (
 	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/a13labs/a13core/logger"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
@@ -49,64 +53,16 @@ func Load(config *PlaylistConfig) (*m3uparser.M3UPlaylist, error) {
 			return nil, errors.New("provider not available '" + providerName + "'")
 		}
 
-		playlist := provider.GetPlaylist()
 		ignoreTags := config.Providers[providerName].IgnoreTags
-		for _, entry := range playlist.Entries {
-
-			skip := false
-			for _, tag := range entry.ExtInfTags {
-				v, ok := ignoreTags[tag.Tag]
-				skip = skip || (ok && v == tag.Value)
-			}
-
-			if skip {
-				logger.Infof("Channel '%s' is ignored, skipping.", entry.Title)
-				continue
-			}
-
-			tvgId := entry.ExtInfTags.GetValue("tvg-id")
-			if tvgId == "" {
-				tvgId = entry.Title
+		for entry, err := range provider.Entries() {
+			if err != nil {
+				return nil, err
 			}
 
-			override, ok := config.Overrides[tvgId]
-			if ok && override.Disabled {
-				logger.Infof("Channel '%s' is disabled, skipping.", entry.Title)
-				continue
-			}
-			if ok && override.ChannelName != "" {
-				entry.Title = override.ChannelName
-			}
-			if ok && override.URL != "" {
-				entry.URI = override.URL
-			}
-			if ok && len(override.Headers) > 0 {
-				for k, v := range override.Headers {
-					entry.Tags = append(entry.Tags, m3uparser.M3UTag{
-						Tag:   "M3UPROXYHEADER",
-						Value: k + "=" + v,
-					})
-				}
+			entry, keep := filterEntry(entry, ignoreTags, config.Overrides)
+			if keep {
+				masterPlaylist.Entries = append(masterPlaylist.Entries, entry)
 			}
-			if ok && override.HttpProxy != "" {
-				entry.Tags = append(entry.Tags, m3uparser.M3UTag{
-					Tag:   "M3UPROXYTRANSPORT",
-					Value: "proxy=" + override.HttpProxy,
-				})
-			}
-			if ok && override.ForceKodiHeaders {
-				entry.Tags = append(entry.Tags, m3uparser.M3UTag{
-					Tag:   "M3UPROXYOPT",
-					Value: "forcekodiheaders",
-				})
-			}
-			if ok && override.DisableRemap {
-				entry.Tags = append(entry.Tags, m3uparser.M3UTag{
-					Tag:   "M3UPROXYOPT",
-					Value: "disableremap",
-				})
-			}
-			masterPlaylist.Entries = append(masterPlaylist.Entries, entry)
 		}
 	}
 
@@ -128,3 +84,101 @@ func Load(config *PlaylistConfig) (*m3uparser.M3UPlaylist, error) {
 
 	return &masterPlaylist, nil
 }
+
+// filterEntry applies the provider's ignored tags and the channel overrides to
+// an entry, returns false if the entry must be skipped.
+func filterEntry(entry m3uparser.M3UEntry, ignoreTags map[string]string, overrides map[string]OverrideEntry) (m3uparser.M3UEntry, bool) {
+
+	skip := false
+	for _, tag := range entry.ExtInfTags {
+		v, ok := ignoreTags[tag.Tag]
+		skip = skip || (ok && v == tag.Value)
+	}
+
+	if skip {
+		logger.Infof("Channel '%s' is ignored, skipping.", entry.Title)
+		return entry, false
+	}
+
+	tvgId := entry.ExtInfTags.GetValue("tvg-id")
+	if tvgId == "" {
+		tvgId = entry.Title
+	}
+
+	override, ok := overrides[tvgId]
+	if !ok {
+		return entry, true
+	}
+	if override.Disabled {
+		logger.Infof("Channel '%s' is disabled, skipping.", entry.Title)
+		return entry, false
+	}
+	if override.ChannelName != "" {
+		entry.Title = override.ChannelName
+	}
+	if override.URL != "" {
+		entry.URI = override.URL
+	}
+	// Sorted so reloads see the same entry
+	for _, k := range slices.Sorted(maps.Keys(override.Headers)) {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYHEADER",
+			Value: k + "=" + override.Headers[k],
+		})
+	}
+	if override.HttpProxy != "" {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYTRANSPORT",
+			Value: "proxy=" + override.HttpProxy,
+		})
+	}
+	if override.ForceKodiHeaders {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYOPT",
+			Value: "forcekodiheaders",
+		})
+	}
+	if override.DisableRemap {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYOPT",
+			Value: "disableremap",
+		})
+	}
+	if override.MaxResolution != "" {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "max_resolution=" + override.MaxResolution,
+		})
+	}
+	if override.MaxBandwidth > 0 {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "max_bandwidth=" + strconv.FormatInt(override.MaxBandwidth, 10),
+		})
+	}
+	if len(override.Codecs) > 0 {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "codecs=" + strings.Join(override.Codecs, ","),
+		})
+	}
+	if override.SingleVariant {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "single_variant",
+		})
+	}
+	if len(override.Languages) > 0 {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "languages=" + strings.Join(override.Languages, ","),
+		})
+	}
+	if override.OnlyLanguages {
+		entry.Tags = append(entry.Tags, m3uparser.M3UTag{
+			Tag:   "M3UPROXYFILTER",
+			Value: "only_languages",
+		})
+	}
+	return entry, true
+}
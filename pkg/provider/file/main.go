@@ -2,18 +2,22 @@ package file
 
 import (
 	"encoding/json"
+	"iter"
 
+	"github.com/a13labs/a13core/logger"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
 	"github.com/a13labs/m3uproxy/pkg/provider/types"
 )
 
 type M3UFileConfig struct {
-	Source string `json:"source"`
+	Source   string `json:"source"`
+	Encoding string `json:"encoding,omitempty"` // e.g. windows-1252, defaults to the HTTP charset or UTF-8
 }
 
 type M3UFileProvider struct {
 	types.M3UProvider
-	playlist m3uparser.M3UPlaylist
+	source   string
+	encoding string
 }
 
 func NewM3UFileProvider(config json.RawMessage) *M3UFileProvider {
@@ -24,17 +28,31 @@ func NewM3UFileProvider(config json.RawMessage) *M3UFileProvider {
 		return nil
 	}
 
-	playlist, err := m3uparser.ParseM3UFile(cfg.Source)
-	if err != nil {
-		return nil
-	}
-
 	return &M3UFileProvider{
-		playlist: *playlist,
+		source:   cfg.Source,
+		encoding: cfg.Encoding,
 	}
 }
 
-func (p *M3UFileProvider) GetPlaylist() *m3uparser.M3UPlaylist {
-
-	return &p.playlist
+// Entries streams the entries from the source, which is read on each call.
+func (p *M3UFileProvider) Entries() iter.Seq2[m3uparser.M3UEntry, error] {
+	return func(yield func(m3uparser.M3UEntry, error) bool) {
+		reader, err := m3uparser.OpenM3UFile(p.source, p.encoding)
+		if err != nil {
+			yield(m3uparser.M3UEntry{}, err)
+			return
+		}
+		defer reader.Close()
+
+		decoder := m3uparser.NewDecoder(reader)
+		for entry, err := range decoder.Entries() {
+			if !yield(entry, err) {
+				return
+			}
+		}
+
+		for _, warning := range decoder.Warnings {
+			logger.Warnf("%s: %s", p.source, warning)
+		}
+	}
 }
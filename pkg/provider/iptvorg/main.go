@@ -3,6 +3,7 @@ package iptvorg
 import (
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
 	"net/url"
 
@@ -216,6 +217,16 @@ func (p *IPTVOrgProvider) GetPlaylist() *m3uparser.M3UPlaylist {
 	return &p.playlist
 }
 
+func (p *IPTVOrgProvider) Entries() iter.Seq2[m3uparser.M3UEntry, error] {
+	return func(yield func(m3uparser.M3UEntry, error) bool) {
+		for _, entry := range p.playlist.Entries {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
 func NewIPTVOrgProvider(config json.RawMessage) *IPTVOrgProvider {
 
 	cfg := IPTVOrgConfig{}
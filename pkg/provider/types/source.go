@@ -1,7 +1,12 @@
 package types
 
-import "github.com/a13labs/m3uproxy/pkg/m3uparser"
+import (
+	"iter"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+)
 
 type M3UProvider interface {
-	GetPlaylist() *m3uparser.M3UPlaylist
+	// Entries iterates over the provider's playlist entries.
+	Entries() iter.Seq2[m3uparser.M3UEntry, error]
 }
@@ -0,0 +1,216 @@
+// Package relay shares a single upstream connection of a continuous stream
+// (MPEG-TS, Icecast, ...) between many clients.
+package relay
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/a13labs/a13core/logger"
+	"github.com/a13labs/m3uproxy/pkg/upstream"
+)
+
+const (
+	// Size of each chunk read from upstream.
+	chunkSize = 32 * 1024
+	// Number of chunks queued per client before it is dropped.
+	clientQueueSize = 64
+	// Bytes kept so new clients start without waiting for upstream.
+	ringBufferSize = 1024 * 1024
+
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+var ErrClientDropped = errors.New("client too slow, dropped")
+
+type client struct {
+	ch      chan []byte
+	dropped bool
+}
+
+// Relay keeps one upstream connection open while it has clients and fans
+// the bytes out to all of them.
+type Relay struct {
+	open    func() (*upstream.UpstreamStream, error)
+	mux     sync.Mutex
+	clients map[*client]struct{}
+	ring    *ringBuffer
+	running bool
+	opening *opening
+	ct      string
+}
+
+// opening is a connection to upstream in progress, clients joining meanwhile
+// wait for it instead of connecting again.
+type opening struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRelay creates a relay, open is called to connect to upstream whenever
+// the first client joins.
+func NewRelay(open func() (*upstream.UpstreamStream, error)) *Relay {
+	return &Relay{
+		open:    open,
+		clients: make(map[*client]struct{}),
+		ring:    newRingBuffer(ringBufferSize),
+	}
+}
+
+// Running reports whether the upstream connection is open.
+func (r *Relay) Running() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.running
+}
+
+// Clients returns the number of connected clients.
+func (r *Relay) Clients() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return len(r.clients)
+}
+
+func (r *Relay) join() (*client, string, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for !r.running {
+		if o := r.opening; o != nil {
+			// Upstream is dialed without the lock, so a slow upstream doesn't
+			// block the other clients and the status of the relay
+			r.mux.Unlock()
+			<-o.done
+			r.mux.Lock()
+			if o.err != nil {
+				return nil, "", o.err
+			}
+			continue
+		}
+
+		o := &opening{done: make(chan struct{})}
+		r.opening = o
+		r.mux.Unlock()
+		stream, err := r.open()
+		r.mux.Lock()
+		r.opening = nil
+		o.err = err
+		close(o.done)
+		if err != nil {
+			return nil, "", err
+		}
+		r.ct = stream.MediaType.String()
+		r.ring.Reset()
+		r.running = true
+		go r.run(stream)
+	}
+
+	c := &client{
+		ch: make(chan []byte, clientQueueSize),
+	}
+	if buffered := r.ring.Bytes(); len(buffered) > 0 {
+		if r.ct == "video/mp2t" || r.ct == "video/m2ts" {
+			buffered = alignTS(buffered)
+		}
+		c.ch <- buffered
+	}
+	r.clients[c] = struct{}{}
+	return c, r.ct, nil
+}
+
+func (r *Relay) leave(c *client) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.removeLocked(c)
+}
+
+func (r *Relay) removeLocked(c *client) {
+	if _, ok := r.clients[c]; ok {
+		delete(r.clients, c)
+		close(c.ch)
+	}
+}
+
+func (r *Relay) run(stream *upstream.UpstreamStream) {
+	defer stream.Close()
+
+	for {
+		// Each chunk is shared read-only by all clients, so it can't be reused
+		buf := make([]byte, chunkSize)
+		n, err := stream.Read(buf)
+
+		r.mux.Lock()
+		if n > 0 {
+			chunk := buf[:n]
+			r.ring.Write(chunk)
+			for c := range r.clients {
+				select {
+				case c.ch <- chunk:
+				default:
+					c.dropped = true
+					r.removeLocked(c)
+				}
+			}
+		}
+
+		if err != nil || len(r.clients) == 0 {
+			if err != nil {
+				logger.Warnf("Relay upstream stopped: %v", err)
+			}
+			for c := range r.clients {
+				r.removeLocked(c)
+			}
+			r.running = false
+			r.mux.Unlock()
+			return
+		}
+		r.mux.Unlock()
+	}
+}
+
+// Serve attaches the client to the relay, connecting to upstream if needed.
+// An error is returned without writing anything if upstream can't be opened.
+func (r *Relay) Serve(w http.ResponseWriter, req *http.Request) error {
+	c, ct, err := r.join()
+	if err != nil {
+		return err
+	}
+	defer r.leave(c)
+
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case chunk, ok := <-c.ch:
+			if !ok {
+				if c.dropped {
+					logger.Warnf("Relay client %s dropped: %v", req.RemoteAddr, ErrClientDropped)
+				}
+				return nil
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return nil
+		}
+	}
+}
+
+// alignTS drops bytes until the start of a MPEG-TS packet.
+func alignTS(data []byte) []byte {
+	for i := 0; i+tsPacketSize < len(data) && i < tsPacketSize; i++ {
+		if data[i] == tsSyncByte && data[i+tsPacketSize] == tsSyncByte {
+			return data[i:]
+		}
+	}
+	return data
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/upstream"
+)
+
+func TestJoinOpensOutsideTheLock(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+	release := make(chan struct{})
+	var opened atomic.Int32
+	r := NewRelay(func() (*upstream.UpstreamStream, error) {
+		opened.Add(1)
+		<-release
+		return nil, errUnavailable
+	})
+
+	const joiners = 5
+	errs := make(chan error, joiners)
+	var wg sync.WaitGroup
+	for range joiners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := r.join()
+			errs <- err
+		}()
+	}
+
+	// The relay answers while upstream is being opened
+	status := make(chan bool)
+	go func() {
+		status <- r.Running() || r.Clients() != 0
+	}()
+	select {
+	case busy := <-status:
+		if busy {
+			t.Errorf("Unexpected relay status while opening")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Relay status blocked while opening upstream")
+	}
+
+	for opened.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Let the other clients join and wait for the connection
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, errUnavailable) {
+			t.Errorf("Unexpected error. Expected: %v, Got: %v", errUnavailable, err)
+		}
+	}
+	if got := opened.Load(); got != 1 {
+		t.Errorf("Unexpected number of upstream connections. Expected: 1, Got: %d", got)
+	}
+
+	// Failures aren't remembered, the next client connects again
+	if _, _, err := r.join(); !errors.Is(err, errUnavailable) {
+		t.Errorf("Unexpected error. Expected: %v, Got: %v", errUnavailable, err)
+	}
+	if got := opened.Load(); got != 2 {
+		t.Errorf("Unexpected number of upstream connections. Expected: 2, Got: %d", got)
+	}
+}
@@ -0,0 +1,52 @@
+package relay
+
+// ringBuffer keeps the most recent bytes written to it.
+type ringBuffer struct {
+	data []byte
+	pos  int
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		data: make([]byte, size),
+	}
+}
+
+func (r *ringBuffer) Write(p []byte) {
+	if len(p) >= len(r.data) {
+		copy(r.data, p[len(p)-len(r.data):])
+		r.pos = 0
+		r.full = true
+		return
+	}
+
+	n := copy(r.data[r.pos:], p)
+	if n < len(p) {
+		copy(r.data, p[n:])
+		r.full = true
+	}
+	r.pos = (r.pos + len(p)) % len(r.data)
+	if r.pos == 0 && len(p) > 0 {
+		r.full = true
+	}
+}
+
+// Bytes returns a copy of the buffered bytes, oldest first.
+func (r *ringBuffer) Bytes() []byte {
+	if !r.full {
+		result := make([]byte, r.pos)
+		copy(result, r.data[:r.pos])
+		return result
+	}
+
+	result := make([]byte, len(r.data))
+	n := copy(result, r.data[r.pos:])
+	copy(result[n:], r.data[:r.pos])
+	return result
+}
+
+func (r *ringBuffer) Reset() {
+	r.pos = 0
+	r.full = false
+}
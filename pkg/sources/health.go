@@ -0,0 +1,282 @@
+package sources
+
+import (
+	"container/heap"
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/a13labs/a13core/logger"
+	"github.com/a13labs/m3uproxy/pkg/sources/types"
+)
+
+// Channels served within this period are being watched.
+const watchedPeriod = 2 * time.Minute
+
+type HealthConfig struct {
+	Interval        time.Duration // healthy sources
+	WatchedInterval time.Duration // sources of channels being watched
+	RetryInterval   time.Duration // first retry of failing sources, doubled on each failure
+	HostConcurrency int           // checks running at once on a host
+	Workers         int
+}
+
+// HealthScheduler checks stream sources in the background, each on its own
+// schedule: healthy sources every Interval, the ones of channels being
+// watched every WatchedInterval and failing ones with an exponential
+// backoff, up to those intervals.
+type HealthScheduler struct {
+	config  HealthConfig
+	mux     sync.Mutex
+	queue   checkQueue
+	entries map[types.StreamSource]*scheduledCheck
+	hosts   map[string]int               // checks running on each host
+	waiting map[string][]*scheduledCheck // due checks of hosts at their limit
+	wake    chan struct{}
+}
+
+type scheduledCheck struct {
+	sources  *Sources
+	source   types.StreamSource
+	host     string
+	next     time.Time
+	progress bool // polling the progress of a live source
+	failures int
+	index    int // in the queue, -1 while waiting, being checked or once removed
+	removed  bool
+}
+
+// checkQueue is a heap of checks ordered by their next run.
+type checkQueue []*scheduledCheck
+
+func (q checkQueue) Len() int           { return len(q) }
+func (q checkQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q checkQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *checkQueue) Push(x any) {
+	check := x.(*scheduledCheck)
+	check.index = len(*q)
+	*q = append(*q, check)
+}
+
+func (q *checkQueue) Pop() any {
+	old := *q
+	check := old[len(old)-1]
+	old[len(old)-1] = nil
+	check.index = -1
+	*q = old[:len(old)-1]
+	return check
+}
+
+func NewHealthScheduler(config HealthConfig) *HealthScheduler {
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Minute
+	}
+	if config.WatchedInterval <= 0 || config.WatchedInterval > config.Interval {
+		config.WatchedInterval = min(30*time.Second, config.Interval)
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 10 * time.Second
+	}
+	if config.HostConcurrency <= 0 {
+		config.HostConcurrency = 2
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	return &HealthScheduler{
+		config:  config,
+		entries: make(map[types.StreamSource]*scheduledCheck),
+		hosts:   make(map[string]int),
+		waiting: make(map[string][]*scheduledCheck),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Add schedules the checks of a source of a channel, the first one right
+// away.
+func (h *HealthScheduler) Add(sources *Sources, source types.StreamSource) {
+	host := ""
+	if uri, err := url.Parse(source.Url()); err == nil {
+		host = uri.Host
+	}
+
+	h.mux.Lock()
+	if _, ok := h.entries[source]; ok {
+		h.mux.Unlock()
+		return
+	}
+	check := &scheduledCheck{sources: sources, source: source, host: host, next: time.Now()}
+	h.entries[source] = check
+	heap.Push(&h.queue, check)
+	h.mux.Unlock()
+
+	h.notify()
+}
+
+// Remove stops checking a source.
+func (h *HealthScheduler) Remove(source types.StreamSource) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	check, ok := h.entries[source]
+	if !ok {
+		return
+	}
+	delete(h.entries, source)
+	check.removed = true
+	if check.index >= 0 {
+		heap.Remove(&h.queue, check.index)
+	}
+}
+
+func (h *HealthScheduler) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches the checks as they are due until the context is done.
+func (h *HealthScheduler) Run(ctx context.Context) {
+	checks := make(chan *scheduledCheck)
+	var wg sync.WaitGroup
+	for i := 0; i < h.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range checks {
+				h.check(check)
+			}
+		}()
+	}
+	defer func() {
+		close(checks)
+		wg.Wait()
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-timer.C:
+		}
+
+		for _, check := range h.due(time.Now()) {
+			select {
+			case checks <- check:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		timer.Reset(h.untilNext(time.Now()))
+	}
+}
+
+// due takes the checks to run now from the queue, the ones of hosts with too
+// many checks running wait for one of them to finish.
+func (h *HealthScheduler) due(now time.Time) []*scheduledCheck {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	var due []*scheduledCheck
+	for h.queue.Len() > 0 && !h.queue[0].next.After(now) {
+		check := heap.Pop(&h.queue).(*scheduledCheck)
+		if h.hosts[check.host] >= h.config.HostConcurrency {
+			h.waiting[check.host] = append(h.waiting[check.host], check)
+			continue
+		}
+		h.hosts[check.host]++
+		due = append(due, check)
+	}
+	return due
+}
+
+// release frees the slot of a finished check on its host, queueing the next
+// check waiting for it.
+func (h *HealthScheduler) release(host string) {
+	h.hosts[host]--
+	if h.hosts[host] <= 0 {
+		delete(h.hosts, host)
+	}
+
+	for len(h.waiting[host]) > 0 {
+		check := h.waiting[host][0]
+		h.waiting[host] = h.waiting[host][1:]
+		if !check.removed {
+			heap.Push(&h.queue, check)
+			break
+		}
+	}
+	if len(h.waiting[host]) == 0 {
+		delete(h.waiting, host)
+	}
+}
+
+func (h *HealthScheduler) untilNext(now time.Time) time.Duration {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.queue.Len() == 0 {
+		return h.config.Interval
+	}
+	return max(h.queue[0].next.Sub(now), 0)
+}
+
+func (h *HealthScheduler) check(check *scheduledCheck) {
+	var err error
+	if check.progress {
+		err = check.sources.CheckProgress(check.source)
+	} else {
+		err = check.sources.CheckSource(check.source)
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.release(check.host)
+	defer h.notify()
+	if check.removed {
+		return
+	}
+
+	// Live sources are polled again when their next segment is due, instead
+	// of holding a worker until then
+	if err == nil {
+		if due, ok := check.source.ProgressDue(); ok {
+			check.progress = true
+			check.next = due
+			heap.Push(&h.queue, check)
+			return
+		}
+	}
+	check.progress = false
+
+	interval := h.config.Interval
+	if check.sources.Watched(watchedPeriod) {
+		interval = h.config.WatchedInterval
+	}
+	if err != nil {
+		if check.failures == 0 {
+			logger.Warnf("Stream source %s is not healthy: %v", check.source.Url(), err)
+		}
+		check.failures++
+		interval = min(h.config.RetryInterval<<min(check.failures-1, 16), interval)
+	} else {
+		if check.failures > 0 {
+			logger.Infof("Stream source %s is healthy again", check.source.Url())
+		}
+		check.failures = 0
+	}
+
+	check.next = time.Now().Add(interval)
+	heap.Push(&h.queue, check)
+}
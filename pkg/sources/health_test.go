@@ -0,0 +1,143 @@
+package sources
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// checkedSource is a fakeSource whose health checks return its error.
+type checkedSource struct {
+	*fakeSource
+}
+
+func (c checkedSource) HealthCheck() error             { return c.err }
+func (c checkedSource) ProgressDue() (time.Time, bool) { return time.Time{}, false }
+
+func TestHealthSchedulerBackoff(t *testing.T) {
+	h := NewHealthScheduler(HealthConfig{
+		Interval:        5 * time.Minute,
+		WatchedInterval: 30 * time.Second,
+		RetryInterval:   10 * time.Second,
+	})
+	unreachable := errors.New("unreachable")
+	fake := newFakeSource("provider/1", unreachable)
+	source := checkedSource{fake}
+	sources := newTestSources(fake)
+	h.Add(sources, source)
+
+	tests := []struct {
+		name     string
+		previous int
+		err      error
+		watched  bool
+		failures int
+		expected time.Duration
+	}{
+		{"first failure", 0, unreachable, false, 1, 10 * time.Second},
+		{"second failure", 1, unreachable, false, 2, 20 * time.Second},
+		{"third failure", 2, unreachable, false, 3, 40 * time.Second},
+		{"backoff up to the watched interval", 3, unreachable, true, 4, 30 * time.Second},
+		{"backoff up to the interval", 5, unreachable, false, 6, 5 * time.Minute},
+		{"healthy again", 6, nil, false, 0, 5 * time.Minute},
+		{"healthy and watched", 0, nil, true, 0, 30 * time.Second},
+		{"failure after recovering", 0, unreachable, false, 1, 10 * time.Second},
+	}
+
+	check := h.entries[source]
+	for _, tt := range tests {
+		fake.err = tt.err
+		if tt.watched {
+			sources.lastServed.Store(time.Now().UnixNano())
+		} else {
+			sources.lastServed.Store(0)
+		}
+
+		// Run the check as the scheduler would once it's due
+		h.mux.Lock()
+		check.failures = tt.previous
+		check.next = time.Now()
+		h.mux.Unlock()
+		due := h.due(time.Now())
+		if len(due) != 1 || due[0] != check {
+			t.Fatalf("%s: Unexpected due checks: %d", tt.name, len(due))
+		}
+
+		start := time.Now()
+		h.check(check)
+		end := time.Now()
+
+		if check.failures != tt.failures {
+			t.Errorf("%s: Unexpected failures. Expected: %v, Got: %v", tt.name, tt.failures, check.failures)
+		}
+		if check.next.Before(start.Add(tt.expected)) || check.next.After(end.Add(tt.expected)) {
+			t.Errorf("%s: Unexpected next check. Expected: %v, Got: %v", tt.name, tt.expected, check.next.Sub(start))
+		}
+	}
+}
+
+func TestHealthSchedulerHostConcurrency(t *testing.T) {
+	h := NewHealthScheduler(HealthConfig{HostConcurrency: 2})
+	var sources []checkedSource
+	for _, name := range []string{"provider/1", "provider/2", "provider/3", "provider/4", "other/1"} {
+		source := checkedSource{newFakeSource(name, nil)}
+		h.Add(newTestSources(source.fakeSource), source)
+		sources = append(sources, source)
+	}
+
+	running := func(due []*scheduledCheck) map[string]int {
+		hosts := make(map[string]int)
+		for _, check := range due {
+			hosts[check.host]++
+		}
+		return hosts
+	}
+
+	due := h.due(time.Now())
+	if hosts := running(due); hosts["provider"] != 2 || hosts["other"] != 1 {
+		t.Fatalf("Unexpected running checks. Expected: map[other:1 provider:2], Got: %v", hosts)
+	}
+	if len(h.waiting["provider"]) != 2 {
+		t.Errorf("Unexpected waiting checks. Expected: 2, Got: %d", len(h.waiting["provider"]))
+	}
+	if again := h.due(time.Now()); len(again) != 0 {
+		t.Errorf("Unexpected due checks while at the limit: %d", len(again))
+	}
+
+	// A removed source waiting for its host is skipped
+	var waiting []*scheduledCheck
+	waiting = append(waiting, h.waiting["provider"]...)
+	h.Remove(waiting[0].source)
+
+	var first *scheduledCheck
+	for _, check := range due {
+		if check.host == "provider" {
+			first = check
+			break
+		}
+	}
+	h.check(first)
+
+	next := h.due(time.Now())
+	if len(next) != 1 || next[0] != waiting[1] {
+		t.Fatalf("Unexpected checks after one finished. Expected: %s, Got: %d checks", waiting[1].source.Url(), len(next))
+	}
+	if h.hosts["provider"] != 2 {
+		t.Errorf("Unexpected running checks on host. Expected: 2, Got: %d", h.hosts["provider"])
+	}
+	if _, ok := h.waiting["provider"]; ok {
+		t.Errorf("Unexpected checks still waiting: %d", len(h.waiting["provider"]))
+	}
+
+	for _, check := range append(due, next...) {
+		if check != first {
+			h.check(check)
+		}
+	}
+	if len(h.hosts) != 0 {
+		t.Errorf("Unexpected running checks. Expected: none, Got: %v", h.hosts)
+	}
+	if h.queue.Len() != len(sources)-1 {
+		t.Errorf("Unexpected queued checks. Expected: %d, Got: %d", len(sources)-1, h.queue.Len())
+	}
+}
@@ -1,18 +1,25 @@
 package sources
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/a13labs/a13core/logger"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
 	"github.com/a13labs/m3uproxy/pkg/sources/types"
 )
 
 type Sources struct {
 	sources      []types.StreamSource
+	entries      []string // playlist entries of the sources
 	mux          *sync.RWMutex
 	activeSource types.StreamSource
+	sequencer    *types.MediaSequencer
+	lastServed   *atomic.Int64 // unix nanoseconds
 }
 
 type SourcesDiag struct {
@@ -24,35 +31,76 @@ func NewSources() Sources {
 		sources:      make([]types.StreamSource, 0),
 		mux:          &sync.RWMutex{},
 		activeSource: nil,
+		sequencer:    types.NewMediaSequencer(),
+		lastServed:   &atomic.Int64{},
 	}
 }
 
-func (s *Sources) SourceExists(entry m3uparser.M3UEntry) bool {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+func (s *Sources) newSource(entry m3uparser.M3UEntry, timeout int) (types.StreamSource, error) {
+	source, err := types.NewSource(entry, timeout)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, source := range s.sources {
-		if source.Url() == entry.URI {
-			return true
-		}
+	if sequenced, ok := source.(interface{ SetMediaSequencer(*types.MediaSequencer) }); ok {
+		sequenced.SetMediaSequencer(s.sequencer)
 	}
-	return false
+	return source, nil
 }
 
-func (s *Sources) AddSource(entry m3uparser.M3UEntry, timeout int) (bool, error) {
-	if s.SourceExists(entry) {
-		return false, fmt.Errorf("source already exists")
+// Sync replaces the sources by the ones of the playlist entries, in order,
+// keeping the sources of unchanged entries. The sources are swapped at once,
+// requests being served keep using the previous ones. Entries whose source
+// can't be created are skipped. It returns the sources added and removed.
+func (s *Sources) Sync(entries []m3uparser.M3UEntry, timeout int) ([]types.StreamSource, []types.StreamSource) {
+	s.mux.RLock()
+	current := make(map[string]types.StreamSource, len(s.sources))
+	for i, source := range s.sources {
+		current[s.entries[i]] = source
 	}
+	s.mux.RUnlock()
 
-	source, err := types.NewSource(entry, timeout)
-	if err != nil {
-		return false, err
+	var added, removed, synced []types.StreamSource
+	var keys []string
+	for _, entry := range entries {
+		key := entry.String()
+		if slices.Contains(keys, key) {
+			continue
+		}
+
+		source, ok := current[key]
+		if ok {
+			delete(current, key)
+		} else {
+			var err error
+			source, err = s.newSource(entry, timeout)
+			if err != nil {
+				logger.Errorf("Error adding stream source for %s: %v", entry.URI, err)
+				continue
+			}
+			added = append(added, source)
+		}
+		synced = append(synced, source)
+		keys = append(keys, key)
+	}
+	for _, source := range current {
+		removed = append(removed, source)
 	}
 
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.sources = append(s.sources, source)
-	return true, nil
+	s.sources = synced
+	s.entries = keys
+	if s.activeSource != nil && !slices.Contains(synced, s.activeSource) {
+		s.activeSource = nil
+		for _, source := range synced {
+			if source.Active() {
+				s.activeSource = source
+				break
+			}
+		}
+	}
+	return added, removed
 }
 
 func (s *Sources) GetActiveSource() types.StreamSource {
@@ -75,47 +123,131 @@ func (s *Sources) Diagnostic() SourcesDiag {
 	return result
 }
 
-func (s *Sources) HealthCheck() error {
-	var activeSource types.StreamSource = nil
-	for _, source := range s.sources {
-		_ = source.HealthCheck()
-		if source.Active() {
-			activeSource = source
+// CheckSource runs the health check of one source, then makes the first
+// healthy source the active one.
+func (s *Sources) CheckSource(source types.StreamSource) error {
+	err := source.HealthCheck()
+	s.selectActive()
+	return err
+}
+
+// CheckProgress checks a live source advances, as CheckSource does.
+func (s *Sources) CheckProgress(source types.StreamSource) error {
+	err := source.CheckProgress()
+	s.selectActive()
+	return err
+}
+
+func (s *Sources) selectActive() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.activeSource = nil
+	for _, candidate := range s.sources {
+		if candidate.Active() {
+			s.activeSource = candidate
 			break
 		}
 	}
+}
+
+// Watched tells if the channel was served within the period.
+func (s *Sources) Watched(period time.Duration) bool {
+	return time.Since(time.Unix(0, s.lastServed.Load())) < period
+}
+
+// failover marks the failed source as inactive and switches the active source
+// to the next healthy one, returns the new active source or nil if none left.
+func (s *Sources) failover(failed types.StreamSource) types.StreamSource {
+	failed.SetActive(false)
 
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.activeSource = activeSource
-	if activeSource == nil {
-		return fmt.Errorf("no active stream source found")
+
+	// Another request may already have switched sources
+	if s.activeSource != nil && s.activeSource != failed && s.activeSource.Active() {
+		return s.activeSource
 	}
-	return nil
-}
 
-func (s *Sources) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+	start := 0
+	for i, source := range s.sources {
+		if source == failed {
+			start = i + 1
+			break
+		}
+	}
 
-	if s.activeSource == nil {
-		http.Error(w, "No active stream source", http.StatusServiceUnavailable)
-		return
+	s.activeSource = nil
+	for i := 0; i < len(s.sources); i++ {
+		source := s.sources[(start+i)%len(s.sources)]
+		if source != failed && source.Active() {
+			s.activeSource = source
+			break
+		}
 	}
 
-	s.activeSource.ServeManifest(w, r, timeout)
+	if s.activeSource == nil {
+		logger.Errorf("Stream source %s failed, no healthy source left", failed.Url())
+	} else {
+		logger.Warnf("Stream source %s failed, switching to %s", failed.Url(), s.activeSource.Url())
+	}
+	return s.activeSource
 }
 
-func (s *Sources) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+func (s *Sources) serve(w http.ResponseWriter, r *http.Request, timeout int, manifest bool) {
+	s.lastServed.Store(time.Now().UnixNano())
+	source := s.GetActiveSource()
 
-	if s.activeSource == nil {
-		http.Error(w, "No active stream source", http.StatusServiceUnavailable)
-		return
+	for source != nil {
+		var err error
+		if manifest {
+			err = source.ServeManifest(w, r, timeout)
+		} else {
+			err = source.ServeMedia(w, r, timeout)
+		}
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, types.ErrInvalidSignature) || errors.Is(err, types.ErrExpiredUrl) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if errors.Is(err, types.ErrInvalidRequest) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if !errors.Is(err, types.ErrUpstream) {
+			logger.Errorf("Error serving %s: %v", r.URL.Path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Media URLs point to the origin of the source that generated them,
+		// they can't be retried on another source. The source is switched
+		// still, so the next manifest request goes to the next one.
+		if !manifest {
+			logger.Warnf("Upstream media request failed on %s: %v", source.Url(), err)
+			s.failover(source)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+
+		logger.Warnf("Upstream request failed on %s: %v", source.Url(), err)
+		source = s.failover(source)
+		r = types.WithFailover(r)
 	}
 
-	s.activeSource.ServeMedia(w, r, timeout)
+	http.Error(w, "No active stream source", http.StatusServiceUnavailable)
+}
+
+func (s *Sources) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) {
+	s.serve(w, r, timeout, true)
+}
+
+func (s *Sources) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) {
+	s.serve(w, r, timeout, false)
 }
 
 func (s *Sources) Active() bool {
@@ -142,6 +274,19 @@ func (s *Sources) MasterPlaylist() string {
 	return ""
 }
 
+// HLSPlaylist returns the path of a HLS playlist of the active source, the
+// master playlist of HLS sources or the HLS rendition of DASH sources. It is
+// empty if the source can't be served as HLS.
+func (s *Sources) HLSPlaylist() string {
+	switch source := s.GetActiveSource().(type) {
+	case *types.M3U8StreamSource:
+		return source.MasterPlaylist()
+	case *types.MPDStreamSource:
+		return source.HLSPlaylist()
+	}
+	return ""
+}
+
 func (s *Sources) M3UTags() m3uparser.M3UTags {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -151,6 +296,15 @@ func (s *Sources) M3UTags() m3uparser.M3UTags {
 	return nil
 }
 
+func (s *Sources) ExtInfTags() m3uparser.M3UExtinfTags {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.activeSource != nil {
+		return s.activeSource.ExtInfTags()
+	}
+	return nil
+}
+
 func (s *Sources) IsRadio() bool {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
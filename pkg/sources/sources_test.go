@@ -0,0 +1,114 @@
+package sources
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/a13labs/m3uproxy/pkg/sources/types"
+)
+
+// fakeSource serves requests with the given error, nil writing its name.
+type fakeSource struct {
+	types.StreamSource
+	name   string
+	err    error
+	active atomic.Bool
+	served atomic.Int32
+}
+
+func newFakeSource(name string, err error) *fakeSource {
+	source := &fakeSource{name: name, err: err}
+	source.active.Store(true)
+	return source
+}
+
+func (f *fakeSource) serve(w http.ResponseWriter) error {
+	f.served.Add(1)
+	if f.err != nil {
+		return f.err
+	}
+	w.Write([]byte(f.name))
+	return nil
+}
+
+func (f *fakeSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) error {
+	return f.serve(w)
+}
+
+func (f *fakeSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) error {
+	return f.serve(w)
+}
+
+func (f *fakeSource) Active() bool          { return f.active.Load() }
+func (f *fakeSource) SetActive(active bool) { f.active.Store(active) }
+func (f *fakeSource) Url() string           { return "http://" + f.name }
+
+func newTestSources(sources ...*fakeSource) *Sources {
+	s := NewSources()
+	for _, source := range sources {
+		s.sources = append(s.sources, source)
+		s.entries = append(s.entries, source.name)
+	}
+	s.activeSource = s.sources[0]
+	return &s
+}
+
+func TestServeFailover(t *testing.T) {
+	upstreamErr := fmt.Errorf("%w: http response code (404)", types.ErrUpstream)
+
+	tests := []struct {
+		name         string
+		manifest     bool
+		firstErr     error
+		secondErr    error
+		status       int
+		body         string
+		firstActive  bool
+		activeSource string
+		secondServed int32
+	}{
+		{"manifest served", true, nil, nil, http.StatusOK, "first", true, "first", 0},
+		{"manifest failover", true, upstreamErr, nil, http.StatusOK, "second", false, "second", 1},
+		{"manifest no source left", true, upstreamErr, upstreamErr, http.StatusServiceUnavailable, "", false, "", 1},
+		{"media switches without retry", false, upstreamErr, nil, http.StatusBadGateway, "", false, "second", 0},
+		{"media invalid request", false, types.ErrInvalidRequest, nil, http.StatusNotFound, "", true, "first", 0},
+		{"media expired url", false, types.ErrExpiredUrl, nil, http.StatusForbidden, "", true, "first", 0},
+	}
+
+	for _, tt := range tests {
+		first := newFakeSource("first", tt.firstErr)
+		second := newFakeSource("second", tt.secondErr)
+		s := newTestSources(first, second)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.manifest {
+			s.ServeManifest(w, r, 5)
+		} else {
+			s.ServeMedia(w, r, 5)
+		}
+
+		if w.Code != tt.status {
+			t.Errorf("%s: Unexpected status. Expected: %d, Got: %d", tt.name, tt.status, w.Code)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: Unexpected body. Expected: %s, Got: %s", tt.name, tt.body, w.Body.String())
+		}
+		if first.Active() != tt.firstActive {
+			t.Errorf("%s: Unexpected first source state. Expected active: %v", tt.name, tt.firstActive)
+		}
+		activeSource := ""
+		if active := s.GetActiveSource(); active != nil {
+			activeSource = active.(*fakeSource).name
+		}
+		if activeSource != tt.activeSource {
+			t.Errorf("%s: Unexpected active source. Expected: %s, Got: %s", tt.name, tt.activeSource, activeSource)
+		}
+		if second.served.Load() != tt.secondServed {
+			t.Errorf("%s: Unexpected requests to the second source. Expected: %d, Got: %d", tt.name, tt.secondServed, second.served.Load())
+		}
+	}
+}
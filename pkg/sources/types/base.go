@@ -3,16 +3,42 @@ package types
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/a13labs/a13core/logger"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+	"github.com/a13labs/m3uproxy/pkg/upstream"
 	"github.com/elnormous/contenttype"
 )
 
 func (s *BaseStreamSource) HealthCheck() error {
+	stats := HealthStats{CheckedAt: time.Now()}
+	s.mux.Lock()
+	s.progress = nil
+	s.mux.Unlock()
+
+	err := s.healthCheck(&stats)
+	if err != nil {
+		stats.Error = err.Error()
+	}
+
+	s.mux.Lock()
+	s.active = err == nil
+	s.health = stats
+	s.mux.Unlock()
+
+	return err
+}
+
+func (s *BaseStreamSource) healthCheck(stats *HealthStats) error {
 	uri, _, err := s.conn.Check("GET", s.m3u.URI)
 	if err != nil {
 		return err
@@ -24,12 +50,7 @@ func (s *BaseStreamSource) HealthCheck() error {
 	}
 	s.mux.Unlock()
 
-	_, err = s.verify(s.m3u.URI)
-
-	s.mux.Lock()
-	s.active = err == nil
-	s.mux.Unlock()
-
+	_, err = s.verify(s.m3u.URI, stats)
 	return err
 }
 
@@ -58,6 +79,7 @@ func (s *BaseStreamSource) Diagnostic() StreamSourceDiag {
 	s.mux.Unlock()
 
 	s.verifyWithDiags(s.m3u.URI, &diag)
+	diag.Health = s.healthStats()
 	return diag
 }
 
@@ -67,6 +89,12 @@ func (s *BaseStreamSource) Active() bool {
 	return s.active
 }
 
+func (s *BaseStreamSource) SetActive(active bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.active = active
+}
+
 func (s *BaseStreamSource) MediaType() contenttype.MediaType {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -85,6 +113,12 @@ func (s *BaseStreamSource) M3UTags() m3uparser.M3UTags {
 	return s.m3u.Tags
 }
 
+func (s *BaseStreamSource) ExtInfTags() m3uparser.M3UExtinfTags {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.m3u.ExtInfTags
+}
+
 func (s *BaseStreamSource) IsRadio() bool {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -97,19 +131,43 @@ func (s *BaseStreamSource) Url() string {
 	return s.m3u.URI
 }
 
-func (s *BaseStreamSource) verify(mediaURI string) (contenttype.MediaType, error) {
+// readManifest reads the body of manifests, media bodies are left unread as
+// continuous streams never end.
+func readManifest(stream *upstream.UpstreamStream) ([]byte, error) {
+	if !stream.MediaType.MatchesAny(manifestMediaTypes...) {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(stream, maxManifestSize))
+}
+
+// verify follows the manifests of a source down to a real segment, or reads
+// the start of continuous streams. Live HLS and DASH manifests must also
+// advance, see CheckProgress.
+func (s *BaseStreamSource) verify(mediaURI string, stats *HealthStats) (contenttype.MediaType, error) {
+	start := time.Now()
 	s.mux.RLock()
-	body, _, ct, err := s.conn.Get("GET", mediaURI)
+	stream, err := s.conn.Stream("GET", mediaURI)
 	s.mux.RUnlock()
 	if err != nil {
 		return contenttype.MediaType{}, err
 	}
+	defer stream.Close()
 
+	ct := stream.MediaType
 	if !ct.MatchesAny(supportedMediaTypes...) {
 		return contenttype.MediaType{}, errors.New("invalid content type")
 	}
 
+	body, err := readManifest(stream)
+	if err != nil {
+		return contenttype.MediaType{}, err
+	}
+
 	if ct.Subtype == "vnd.apple.mpegurl" || ct.Subtype == "x-mpegurl" {
+		if !bytes.Contains(body, []byte("#EXT-X-STREAM-INF")) {
+			return ct, s.verifyMediaPlaylist(mediaURI, body, stats)
+		}
+
 		m3uPlaylist, err := m3uparser.DecodeFromReader(bytes.NewReader(body))
 		if err != nil {
 			return contenttype.MediaType{}, err
@@ -133,16 +191,110 @@ func (s *BaseStreamSource) verify(mediaURI string) (contenttype.MediaType, error
 			}
 		}
 
-		return s.verify(uri.String())
+		return s.verify(uri.String(), stats)
+	}
+
+	if ct.Subtype == "dash+xml" {
+		mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+		if err != nil {
+			return contenttype.MediaType{}, err
+		}
+		s.mux.Lock()
+		s.protected = isProtected(mpdPlaylist)
+		s.mux.Unlock()
+
+		segment, live, err := dashSegment(mediaURI, mpdPlaylist)
+		if err != nil {
+			return contenttype.MediaType{}, err
+		}
+		if err := s.probeSegment(segment.URL, segment.Length(), stats); err != nil {
+			return contenttype.MediaType{}, err
+		}
+		if live {
+			s.expectProgress(segment.URL, segment.Length(), func() (string, error) {
+				body, _, _, err := s.conn.Get("GET", mediaURI)
+				if err != nil {
+					return "", err
+				}
+				mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+				if err != nil {
+					return "", err
+				}
+				segment, _, err := dashSegment(mediaURI, mpdPlaylist)
+				if err != nil {
+					return "", err
+				}
+				return segment.URL, nil
+			})
+		}
+		return ct, nil
+	}
+
+	if ct.MatchesAny(continuousMediaTypes...) {
+		stats.SegmentURL = mediaURI
+		if err := probeContinuous(stream, start, stats); err != nil {
+			return contenttype.MediaType{}, err
+		}
 	}
 
 	return ct, nil
 }
 
+// verifyMediaPlaylist probes the newest segment of a live media playlist, or
+// the first of a VOD one. Live ones must then advance, see CheckProgress.
+func (s *BaseStreamSource) verifyMediaPlaylist(mediaURI string, body []byte, stats *HealthStats) error {
+	playlist := scanMediaSegments(body)
+	if len(playlist.segments) == 0 {
+		return errors.New("empty playlist")
+	}
+
+	segment := playlist.segments[0]
+	if !playlist.endList {
+		segment = playlist.segments[len(playlist.segments)-1]
+	}
+	segmentURI, err := resolveReference(mediaURI, segment.uri)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(segment.duration * float64(time.Second))
+	if err := s.probeSegment(segmentURI.String(), duration, stats); err != nil {
+		return err
+	}
+	if playlist.endList {
+		return nil
+	}
+
+	s.expectProgress(playlist.newest(), time.Duration(playlist.targetDuration*float64(time.Second)), func() (string, error) {
+		body, _, _, err := s.conn.Get("GET", mediaURI)
+		if err != nil {
+			return "", err
+		}
+		playlist := scanMediaSegments(body)
+		if len(playlist.segments) == 0 {
+			return "", errors.New("empty playlist")
+		}
+		return playlist.newest(), nil
+	})
+	return nil
+}
+
 func (s *BaseStreamSource) verifyWithDiags(mediaURI string, diag *StreamSourceDiag) {
 	s.mux.RLock()
-	body, status, ct, err := s.conn.Get("GET", mediaURI)
+	stream, err := s.conn.Stream("GET", mediaURI)
 	s.mux.RUnlock()
+	if err != nil {
+		diag.Diagnostics = append(diag.Diagnostics, HttpDiags{
+			Url:   mediaURI,
+			Error: err.Error(),
+		})
+		return
+	}
+	defer stream.Close()
+
+	status := stream.StatusCode
+	ct := stream.MediaType
+	body, err := readManifest(stream)
 	if err != nil {
 		diag.Diagnostics = append(diag.Diagnostics, HttpDiags{
 			Url:    mediaURI,
@@ -197,6 +349,77 @@ func (s *BaseStreamSource) verifyWithDiags(mediaURI string, diag *StreamSourceDi
 		s.verifyWithDiags(uri.String(), diag)
 	}
 
+	if ct.Subtype == "dash+xml" {
+		mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+		var probe *mpd.Segment
+		if err == nil {
+			probe, _, err = dashSegment(mediaURI, mpdPlaylist)
+		}
+		if err != nil {
+			httpDiag.Error = err.Error()
+			diag.Diagnostics = append(diag.Diagnostics, httpDiag)
+			return
+		}
+
+		segmentDiag := HttpDiags{Url: probe.URL}
+		s.mux.RLock()
+		segment, err := s.conn.Stream("GET", probe.URL)
+		s.mux.RUnlock()
+		if err != nil {
+			segmentDiag.Error = err.Error()
+			diag.Diagnostics = append(diag.Diagnostics, segmentDiag, httpDiag)
+			return
+		}
+		segmentDiag.Status = segment.StatusCode
+		segmentDiag.MediaType = segment.MediaType.String()
+		segment.Close()
+		diag.Diagnostics = append(diag.Diagnostics, segmentDiag)
+	}
+
 	diag.Diagnostics = append(diag.Diagnostics, httpDiag)
 	diag.Active = status == http.StatusOK
 }
+
+func resolveReference(base string, ref string) (*url.URL, error) {
+	baseURI, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	refURI, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return baseURI.ResolveReference(refURI), nil
+}
+
+// relayMedia streams the upstream media to the client without buffering the
+// whole body.
+func (s *BaseStreamSource) relayMedia(w http.ResponseWriter, r *http.Request, uri *url.URL) error {
+	stream, err := s.conn.Stream("GET", uri.String())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	defer stream.Close()
+	return relayStream(w, r, uri, stream, nil)
+}
+
+// relayStream sends an upstream response to the client, head being the part
+// of the body already read from the stream.
+func relayStream(w http.ResponseWriter, r *http.Request, uri *url.URL, stream *upstream.UpstreamStream, head []byte) error {
+	w.Header().Set("Content-Type", stream.MediaType.String())
+	if stream.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(stream.ContentLength))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if len(head) > 0 {
+		if _, err := w.Write(head); err != nil {
+			logger.Debugf("Media relay for %s stopped: %v", uri.String(), err)
+			return nil
+		}
+	}
+	if _, err := stream.Relay(r.Context(), w); err != nil {
+		logger.Debugf("Media relay for %s stopped: %v", uri.String(), err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/cache"
+	"github.com/a13labs/m3uproxy/pkg/upstream"
+)
+
+const (
+	// Used when the playlist didn't tell how long segments stay available.
+	defaultSegmentTTL = 30 * time.Second
+	// Used for manifests without a target duration or update period.
+	defaultManifestTTL = 2 * time.Second
+)
+
+var segmentCache *cache.SegmentCache
+
+type SegmentCacheDiag struct {
+	Hits   int64                   `json:"hits"`
+	Misses int64                   `json:"misses"`
+	Usage  cache.SegmentCacheStats `json:"usage"`
+}
+
+// cacheCounters tracks the segment cache hits and misses of a source.
+type cacheCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// SetSegmentCache enables the segment cache shared by all HLS sources, nil
+// disables it.
+func SetSegmentCache(c *cache.SegmentCache) {
+	segmentCache = c
+}
+
+func (c *cacheCounters) diagnostic() *SegmentCacheDiag {
+	if segmentCache == nil {
+		return nil
+	}
+	return &SegmentCacheDiag{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Usage:  segmentCache.Stats(),
+	}
+}
+
+// errNotCacheable is returned by segment fetches whose response is too large
+// for the cache, the response is relayed instead.
+var errNotCacheable = errors.New("segment too large for the cache")
+
+// serveCachedMedia serves a segment from the shared cache, fetching it once
+// from upstream for all concurrent viewers on a miss. Segments too large for
+// the cache are relayed as they are received.
+func (s *BaseStreamSource) serveCachedMedia(w http.ResponseWriter, r *http.Request, uri *url.URL, ttl time.Duration, counters *cacheCounters) error {
+	key := uri.String()
+
+	// The stream and the part of it already read when the fetch found out
+	// the segment can't be cached
+	var stream *upstream.UpstreamStream
+	var head []byte
+	defer func() {
+		if stream != nil {
+			stream.Close()
+		}
+	}()
+
+	segment, hit, err := segmentCache.Get(key, ttl, func() (cache.Segment, error) {
+		response, err := s.conn.Stream("GET", key)
+		if err != nil {
+			return cache.Segment{}, err
+		}
+
+		maxSize := segmentCache.MaxEntrySize()
+		if response.ContentLength > int(maxSize) {
+			stream = response
+			return cache.Segment{}, errNotCacheable
+		}
+		data, err := io.ReadAll(io.LimitReader(response, maxSize+1))
+		if err != nil {
+			response.Close()
+			return cache.Segment{}, err
+		}
+		if int64(len(data)) > maxSize {
+			stream, head = response, data
+			return cache.Segment{}, errNotCacheable
+		}
+		response.Close()
+		return cache.Segment{Data: data, MediaType: response.MediaType.String()}, nil
+	})
+	if errors.Is(err, errNotCacheable) {
+		counters.misses.Add(1)
+		if stream == nil {
+			// Another request fetched it, this one gets its own stream
+			return s.relayMedia(w, r, uri)
+		}
+		return relayStream(w, r, uri, stream, head)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+
+	if hit {
+		counters.hits.Add(1)
+	} else {
+		counters.misses.Add(1)
+	}
+
+	w.Header().Set("Content-Type", segment.MediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(segment.Data)
+	return nil
+}
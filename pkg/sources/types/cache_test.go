@@ -0,0 +1,77 @@
+package types
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/cache"
+	"github.com/a13labs/m3uproxy/pkg/upstream"
+)
+
+func TestServeCachedMedia(t *testing.T) {
+	var requests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		body := bytes.Repeat([]byte{0x47}, size)
+		w.Header().Set("Content-Type", "video/mp2t")
+		if r.URL.Path == "/chunked" {
+			// Without a Content-Length the size is only known once read
+			w.Write(body[:size/2])
+			w.(http.Flusher).Flush()
+			w.Write(body[size/2:])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Write(body)
+	}))
+	defer origin.Close()
+
+	SetSegmentCache(cache.NewSegmentCache(8000)) // entries up to 1000 bytes
+	defer SetSegmentCache(nil)
+	source := &BaseStreamSource{conn: upstream.NewUpstreamConnection(nil, "", 5)}
+	var counters cacheCounters
+
+	tests := []struct {
+		name     string
+		path     string
+		size     int
+		requests int32
+		hits     int64
+	}{
+		{"miss", "/segment.ts?size=1000", 1000, 1, 0},
+		{"hit", "/segment.ts?size=1000", 1000, 1, 1},
+		{"relayed", "/large.ts?size=5000", 5000, 2, 1},
+		{"relayed again", "/large.ts?size=5000", 5000, 3, 1},
+		{"relayed once read", "/chunked?size=5000", 5000, 4, 1},
+		{"small without length", "/chunked?size=500", 500, 5, 1},
+		{"small without length hit", "/chunked?size=500", 500, 5, 2},
+	}
+
+	for _, tt := range tests {
+		uri, _ := url.Parse(origin.URL + tt.path)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/media", nil)
+		if err := source.serveCachedMedia(w, r, uri, time.Minute, &counters); err != nil {
+			t.Fatalf("%s: Unexpected error: %v", tt.name, err)
+		}
+		if w.Code != http.StatusOK || w.Body.Len() != tt.size || w.Header().Get("Content-Type") != "video/mp2t" {
+			t.Errorf("%s: Unexpected response. Expected: %d bytes, Got: %d %d bytes %s", tt.name, tt.size, w.Code, w.Body.Len(), w.Header().Get("Content-Type"))
+		}
+		if !bytes.Equal(w.Body.Bytes(), bytes.Repeat([]byte{0x47}, tt.size)) {
+			t.Errorf("%s: Unexpected body", tt.name)
+		}
+		if got := requests.Load(); got != tt.requests {
+			t.Errorf("%s: Unexpected number of upstream requests. Expected: %d, Got: %d", tt.name, tt.requests, got)
+		}
+		if got := counters.hits.Load(); got != tt.hits {
+			t.Errorf("%s: Unexpected number of hits. Expected: %d, Got: %d", tt.name, tt.hits, got)
+		}
+	}
+}
@@ -2,19 +2,31 @@ package types
 
 import (
 	"bytes"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/a13labs/m3uproxy/pkg/cache"
 	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
 	"github.com/gorilla/mux"
 )
 
 type MPDStreamSource struct {
 	BaseStreamSource
+	manifests *cache.ManifestCache
+	sequencer *MediaSequencer
+}
+
+// SetMediaSequencer shares the channel's media sequencer with the source, so
+// its HLS media playlists stay continuous across periods and when switching
+// sources.
+func (s *MPDStreamSource) SetMediaSequencer(sequencer *MediaSequencer) {
+	s.sequencer = sequencer
 }
 
 func (s *MPDStreamSource) parseUrl(r *http.Request) (*url.URL, error) {
@@ -25,112 +37,262 @@ func (s *MPDStreamSource) parseUrl(r *http.Request) (*url.URL, error) {
 		return url.Parse(s.m3u.URI)
 	}
 
-	parts := strings.Split(vars["path"], "/")
+	parts := strings.SplitN(vars["path"], "/", 2)
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("invalid path")
 	}
-	encodedUrl := parts[0]
-	baseUrl, err := base64.URLEncoding.DecodeString(encodedUrl)
+	baseUrl, err := signer.verify(requestChannel(r), parts[0])
 	if err != nil {
 		return nil, err
 	}
 
-	index := strings.Index(vars["path"], "/")
-	targetUrl := vars["path"][index+1:]
-	uri, err := url.Parse(string(baseUrl) + "/" + targetUrl)
+	targetUrl := parts[1]
+	if r.URL.RawQuery != "" {
+		targetUrl += "?" + r.URL.RawQuery
+	}
+	return resolveReference(baseUrl, targetUrl)
+}
+
+// resolveBaseURL applies the first BaseURL of a level to the parent's base,
+// following the DASH BaseURL resolution rules.
+func resolveBaseURL(parent *url.URL, baseURLs []*mpd.BaseURL) *url.URL {
+	if len(baseURLs) == 0 {
+		return parent
+	}
+	ref, err := url.Parse(strings.TrimSpace(baseURLs[0].Value))
 	if err != nil {
-		return nil, err
+		return parent
 	}
+	return parent.ResolveReference(ref)
+}
 
-	return uri, nil
+// proxyBaseURL returns the proxy BaseURL serving the directory of base.
+func proxyBaseURL(base *url.URL, channel string) string {
+	dir := base.ResolveReference(&url.URL{Path: "./"})
+	return fmt.Sprintf("media/%s/", signer.sign(channel, dir.String()))
 }
 
-func (s *MPDStreamSource) remap(body []byte, w http.ResponseWriter, orig *url.URL) {
-	mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+// proxyFileURL returns the proxy URL of a file, under the proxy BaseURL of its
+// directory.
+func proxyFileURL(target *url.URL, channel string) string {
+	file := path.Base(target.Path)
+	if target.RawQuery != "" {
+		file += "?" + target.RawQuery
+	}
+	return proxyBaseURL(target, channel) + file
+}
+
+// remapTemplate rewrites absolute segment URLs and templates, which ignore the
+// BaseURL, so they go through the proxy. They are made relative to the
+// Representation's proxy BaseURL ("media/<token>/").
+func remapTemplate(template *string, base *url.URL, channel string) {
+	if template == nil {
+		return
+	}
+	t := *template
+	if !strings.HasPrefix(t, "http://") && !strings.HasPrefix(t, "https://") && !strings.HasPrefix(t, "/") {
+		return
+	}
+
+	// Identifiers such as $Number%05d$ aren't valid URL escapes, only the
+	// directory before the first identifier is resolved.
+	end := strings.Index(t, "$")
+	if end < 0 {
+		end = len(t)
+	}
+	slash := strings.LastIndex(t[:end], "/")
+	dir, err := url.Parse(t[:slash+1])
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	*template = "../../" + proxyBaseURL(base.ResolveReference(dir), channel) + t[slash+1:]
+}
 
-	for i := range mpdPlaylist.Period {
-		for j := range mpdPlaylist.Period[i].AdaptationSets {
-			for k := range mpdPlaylist.Period[i].AdaptationSets[j].Representations {
+// remapSegments rewrites the absolute URLs of a level's segment information.
+func remapSegments(segmentBase *mpd.SegmentBase, segmentList *mpd.SegmentList, segmentTemplate *mpd.SegmentTemplate, base *url.URL, channel string) {
+	if segmentBase != nil {
+		if segmentBase.Initialization != nil {
+			remapTemplate(segmentBase.Initialization.SourceURL, base, channel)
+		}
+		if segmentBase.RepresentationIndex != nil {
+			remapTemplate(segmentBase.RepresentationIndex.SourceURL, base, channel)
+		}
+	}
 
-				if len(mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL) == 0 {
-					uri := new(url.URL)
-					uri.Scheme = orig.Scheme
-					uri.Host = orig.Host
-					basePath := path.Dir(orig.Path)
-					uri.Path = path.Join(basePath, uri.Path)
-					remap := base64.URLEncoding.EncodeToString([]byte(uri.String()))
-					mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL = append(mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL, &mpd.BaseURL{Value: fmt.Sprintf("media/%s/", remap)})
-					continue
-				}
+	if segmentList != nil {
+		if segmentList.Initialization != nil {
+			remapTemplate(segmentList.Initialization.SourceURL, base, channel)
+		}
+		for _, segmentURL := range segmentList.SegmentURLs {
+			remapTemplate(segmentURL.Media, base, channel)
+			remapTemplate(segmentURL.Index, base, channel)
+		}
+	}
+
+	if segmentTemplate != nil {
+		remapTemplate(segmentTemplate.Media, base, channel)
+		remapTemplate(segmentTemplate.Index, base, channel)
+		remapTemplate(segmentTemplate.Initialization, base, channel)
+	}
+}
+
+func (s *MPDStreamSource) remap(body []byte, w io.Writer, orig *url.URL, channel string, filter RenditionFilter) error {
+	mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 
-				for l := range mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL {
-					currentBaseURL := mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL[l].Value
+	filterMPD(mpdPlaylist, filter)
 
-					uri, _ := url.Parse(currentBaseURL)
+	// Bases above the Representation are folded into its BaseURL, which then
+	// points to the proxy.
+	mpdBase := resolveBaseURL(orig, mpdPlaylist.BaseURL)
+	mpdPlaylist.BaseURL = nil
 
-					if uri.Scheme == "" {
-						uri.Scheme = orig.Scheme
-						uri.Host = string(orig.Host)
-						basePath := path.Dir(string(orig.Path))
-						uri.Path = path.Join(basePath, uri.Path)
-					}
+	// Refreshes must come back to the proxy, the URL the client used
+	mpdPlaylist.Location = nil
+
+	for _, period := range mpdPlaylist.Period {
+		periodBase := resolveBaseURL(mpdBase, period.BaseURL)
+		period.BaseURL = nil
+		remapSegments(period.SegmentBase, period.SegmentList, period.SegmentTemplate, periodBase, channel)
 
-					remap := base64.URLEncoding.EncodeToString([]byte(uri.String()))
-					mpdPlaylist.Period[i].AdaptationSets[j].Representations[k].BaseURL[l].Value = fmt.Sprintf("media/%s/", remap)
+		for _, adaptationSet := range period.AdaptationSets {
+			adaptationSetBase := resolveBaseURL(periodBase, adaptationSet.BaseURL)
+			adaptationSet.BaseURL = nil
+
+			remapSegments(adaptationSet.SegmentBase, adaptationSet.SegmentList, adaptationSet.SegmentTemplate, adaptationSetBase, channel)
+
+			for k := range adaptationSet.Representations {
+				representation := &adaptationSet.Representations[k]
+
+				representationBase := resolveBaseURL(adaptationSetBase, representation.BaseURL)
+				remapSegments(representation.SegmentBase, representation.SegmentList, representation.SegmentTemplate, representationBase, channel)
+
+				if len(representation.BaseURL) == 0 {
+					representation.BaseURL = []*mpd.BaseURL{{Value: proxyBaseURL(adaptationSetBase, channel)}}
+					continue
+				}
+
+				for _, baseURL := range representation.BaseURL {
+					// On-demand representations name their file in the BaseURL
+					base := resolveBaseURL(adaptationSetBase, []*mpd.BaseURL{baseURL})
+					if base.Path != "" && !strings.HasSuffix(base.Path, "/") {
+						baseURL.Value = proxyFileURL(base, channel)
+					} else {
+						baseURL.Value = proxyBaseURL(base, channel)
+					}
 				}
 			}
 		}
 	}
 
-	mpdPlaylist.WriteTo(w)
+	_, err = mpdPlaylist.WriteTo(w)
+	return err
+}
+
+// dashSegment returns a media segment of the manifest, the newest one of live
+// presentations, used to check the stream is playable. It also tells if the
+// presentation is live.
+func dashSegment(manifestURI string, mpdPlaylist *mpd.MPD) (*mpd.Segment, bool, error) {
+	manifest, err := url.Parse(manifestURI)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(mpdPlaylist.Period) == 0 {
+		return nil, false, errors.New("no periods in manifest")
+	}
+
+	live := mpdPlaylist.Type != nil && *mpdPlaylist.Type == "dynamic"
+	period := mpdPlaylist.Period[0]
+	if live {
+		period = mpdPlaylist.Period[len(mpdPlaylist.Period)-1]
+	}
+
+	for _, adaptationSet := range period.AdaptationSets {
+		if len(adaptationSet.Representations) == 0 {
+			continue
+		}
+		index, err := mpdPlaylist.Segments(manifest, period, &adaptationSet.Representations[0], time.Now())
+		if err != nil {
+			return nil, false, err
+		}
+		if len(index.Segments) == 0 {
+			return nil, false, errors.New("no segments available")
+		}
+		if live {
+			return index.Segments[len(index.Segments)-1], true, nil
+		}
+		return index.Segments[0], false, nil
+	}
+	return nil, false, errors.New("no representations in manifest")
 }
 
 func (s *MPDStreamSource) MasterPlaylist() string {
 	return "master.mpd"
 }
 
-func (s *MPDStreamSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) {
+// manifestTTL returns for how long a MPD can be served from cache, a fraction
+// of the minimum update period for dynamic manifests.
+func (s *MPDStreamSource) manifestTTL(body []byte) time.Duration {
+	mpdPlaylist, err := mpd.DecodeFromReader(bytes.NewReader(body))
+	if err != nil || mpdPlaylist.MinimumUpdatePeriod == nil {
+		return defaultManifestTTL
+	}
+	period, err := mpdPlaylist.MinimumUpdatePeriod.ToNanoseconds()
+	if err != nil || period <= 0 {
+		return defaultManifestTTL
+	}
+	return time.Duration(period) / 2
+}
+
+func (s *MPDStreamSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) error {
 
-	uri, err := s.parseUrl(r)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+	if isHLSRequest(r) {
+		return s.serveHLS(w, r)
 	}
 
-	body, _, ct, err := s.conn.Get("GET", uri.String())
+	uri, err := s.parseUrl(r)
+	if isFailover(r) {
+		uri, err = url.Parse(s.m3u.URI)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	if s.disableRemap {
-		w.Write(body)
-		return
+	filter := s.renditionFilter(r)
+	manifest, err := s.manifests.Get(uri.String()+filter.cacheKey(), func() (cache.Manifest, time.Duration, error) {
+		body, _, ct, err := s.conn.Get("GET", uri.String())
+		if err != nil {
+			return cache.Manifest{}, 0, fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+
+		if s.disableRemap {
+			return cache.Manifest{Data: body, MediaType: ct.String()}, s.manifestTTL(body), nil
+		}
+
+		remapped := new(bytes.Buffer)
+		if err := s.remap(body, remapped, uri, requestChannel(r), filter); err != nil {
+			return cache.Manifest{}, 0, err
+		}
+		return cache.Manifest{Data: remapped.Bytes(), MediaType: ct.String()}, s.manifestTTL(body), nil
+	})
+	if err != nil {
+		return err
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	s.remap(body, w, uri)
+	w.Header().Set("Content-Type", manifest.MediaType)
+	w.Write(manifest.Data)
+	return nil
 }
 
-func (s *MPDStreamSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) {
+func (s *MPDStreamSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) error {
 
 	uri, err := s.parseUrl(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	body, _, ct, err := s.conn.Get("GET", uri.String())
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	w.Write(body)
+	return s.relayMedia(w, r, uri)
 }
@@ -0,0 +1,186 @@
+package types
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMPDRemap(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic" availabilityStartTime="2024-01-01T00:00:00Z" minimumUpdatePeriod="PT2S">
+  <BaseURL>https://origin.example.com/live/</BaseURL>
+  <Location>https://origin.example.com/live/manifest.mpd?session=1</Location>
+  <Period id="1" start="PT0S">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="1" duration="2" initialization="https://origin.example.com/init/$RepresentationID$.mp4" media="video/$Number$.m4s"/>
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <BaseURL>https://cdn.example.com/audio/</BaseURL>
+      <SegmentList timescale="1" duration="2">
+        <SegmentURL media="/abs/1.m4s"/>
+        <SegmentURL media="2.m4s"/>
+      </SegmentList>
+      <Representation id="a1" bandwidth="64000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="text/vtt">
+      <Representation id="t1" bandwidth="1000">
+        <BaseURL>https://cdn.example.com/subs/en.vtt</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	orig, _ := url.Parse("https://origin.example.com/live/manifest.mpd")
+	var out bytes.Buffer
+	s := &MPDStreamSource{}
+	if err := s.remap([]byte(manifest), &out, orig, "channel1", RenditionFilter{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	remapped := out.String()
+
+	for _, host := range []string{"origin.example.com", "cdn.example.com"} {
+		if strings.Contains(remapped, host) {
+			t.Errorf("Origin host %s left in the remapped manifest:\n%s", host, remapped)
+		}
+	}
+	if strings.Contains(remapped, "<Location>") {
+		t.Errorf("Location left in the remapped manifest:\n%s", remapped)
+	}
+	if !strings.Contains(remapped, "/en.vtt</BaseURL>") {
+		t.Errorf("File name of the representation BaseURL lost:\n%s", remapped)
+	}
+
+	// Every proxy URL must carry a token of the channel for the origin
+	for _, expected := range []string{
+		"https://origin.example.com/init/",
+		"https://origin.example.com/live/",
+		"https://cdn.example.com/audio/",
+		"https://cdn.example.com/subs/",
+	} {
+		token := signer.sign("channel1", expected)
+		if !strings.Contains(remapped, token) {
+			t.Errorf("No proxy URL for %s in the remapped manifest:\n%s", expected, remapped)
+		}
+	}
+}
+
+func TestProxyURLs(t *testing.T) {
+	tests := []struct {
+		target string
+		dir    string
+		file   string
+	}{
+		{"https://origin.example.com/live/manifest.mpd", "https://origin.example.com/live/", "manifest.mpd"},
+		{"https://origin.example.com/live/", "https://origin.example.com/live/", ""},
+		{"https://cdn.example.com/vod/v1.mp4?token=abc", "https://cdn.example.com/vod/", "v1.mp4?token=abc"},
+	}
+
+	for _, tt := range tests {
+		target, _ := url.Parse(tt.target)
+		expected := "media/" + signer.sign("channel1", tt.dir) + "/"
+		if got := proxyBaseURL(target, "channel1"); got != expected {
+			t.Errorf("Unexpected base URL of %s. Expected: %s, Got: %s", tt.target, expected, got)
+		}
+		if tt.file == "" {
+			continue
+		}
+		if got := proxyFileURL(target, "channel1"); got != expected+tt.file {
+			t.Errorf("Unexpected file URL of %s. Expected: %s, Got: %s", tt.target, expected+tt.file, got)
+		}
+	}
+}
+
+func TestRemapTemplate(t *testing.T) {
+	base, _ := url.Parse("https://origin.example.com/live/")
+	proxied := func(dir string) string {
+		return "../../media/" + signer.sign("channel1", dir) + "/"
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"$RepresentationID$/$Number$.m4s", "$RepresentationID$/$Number$.m4s"},
+		{"video/init.mp4", "video/init.mp4"},
+		{"https://cdn.example.com/v/$RepresentationID$/$Number%05d$.m4s", proxied("https://cdn.example.com/v/") + "$RepresentationID$/$Number%05d$.m4s"},
+		{"https://cdn.example.com/v/init-$RepresentationID$.mp4", proxied("https://cdn.example.com/v/") + "init-$RepresentationID$.mp4"},
+		{"/abs/init.mp4", proxied("https://origin.example.com/abs/") + "init.mp4"},
+	}
+
+	for _, tt := range tests {
+		template := tt.template
+		remapTemplate(&template, base, "channel1")
+		if template != tt.expected {
+			t.Errorf("Unexpected template of %s. Expected: %s, Got: %s", tt.template, tt.expected, template)
+		}
+	}
+	remapTemplate(nil, base, "channel1")
+}
+
+func TestMPDRemap_BaseURLLevels(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT60S">
+  <BaseURL>https://origin.example.com/root/</BaseURL>
+  <Period id="1">
+    <BaseURL>period/</BaseURL>
+    <AdaptationSet mimeType="video/mp4">
+      <BaseURL>video/</BaseURL>
+      <Representation id="v1" bandwidth="500000">
+        <BaseURL>v1/</BaseURL>
+      </Representation>
+      <Representation id="v2" bandwidth="800000"/>
+      <Representation id="v3" bandwidth="1000000">
+        <BaseURL>/other/v3.mp4</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+  <Period id="2">
+    <BaseURL>https://cdn.example.com/period2/</BaseURL>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	orig, _ := url.Parse("https://origin.example.com/live/manifest.mpd")
+	var out bytes.Buffer
+	if err := (&MPDStreamSource{}).remap([]byte(manifest), &out, orig, "channel1", RenditionFilter{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mpdPlaylist := decodeTestMPD(t, out.String())
+
+	proxied := func(dir string) string {
+		return "media/" + signer.sign("channel1", dir) + "/"
+	}
+	expected := []string{
+		proxied("https://origin.example.com/root/period/video/v1/"),
+		proxied("https://origin.example.com/root/period/video/"),
+		proxied("https://origin.example.com/other/") + "v3.mp4",
+		proxied("https://cdn.example.com/period2/"),
+	}
+	var got []string
+	for _, period := range mpdPlaylist.Period {
+		if len(period.BaseURL) > 0 {
+			t.Errorf("Unexpected Period BaseURL: %s", period.BaseURL[0].Value)
+		}
+		for _, adaptationSet := range period.AdaptationSets {
+			if len(adaptationSet.BaseURL) > 0 {
+				t.Errorf("Unexpected AdaptationSet BaseURL: %s", adaptationSet.BaseURL[0].Value)
+			}
+			for _, representation := range adaptationSet.Representations {
+				for _, baseURL := range representation.BaseURL {
+					got = append(got, baseURL.Value)
+				}
+			}
+		}
+	}
+	if len(mpdPlaylist.BaseURL) > 0 {
+		t.Errorf("Unexpected MPD BaseURL: %s", mpdPlaylist.BaseURL[0].Value)
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected representation BaseURLs. Expected: %q, Got: %q", expected, got)
+	}
+}
@@ -0,0 +1,398 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/cache"
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+	"github.com/gorilla/mux"
+)
+
+// DASH sources with clear content are also served as HLS: master.m3u8 lists
+// the representations, hls/<representation id>.m3u8 are fMP4 media playlists
+// built from the MPD's segments.
+const (
+	hlsMasterPlaylist = "master.m3u8"
+	hlsMediaPrefix    = "hls/"
+	hlsAudioGroup     = "audio"
+)
+
+// fetchMPD returns the upstream MPD, the original manifest is cached as the
+// remapped ones are.
+func (s *MPDStreamSource) fetchMPD(uri *url.URL) (*mpd.MPD, error) {
+	manifest, err := s.manifests.Get("mpd:"+uri.String(), func() (cache.Manifest, time.Duration, error) {
+		body, _, ct, err := s.conn.Get("GET", uri.String())
+		if err != nil {
+			return cache.Manifest{}, 0, fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+		return cache.Manifest{Data: body, MediaType: ct.String()}, s.manifestTTL(body), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mpd.DecodeFromReader(bytes.NewReader(manifest.Data))
+}
+
+// HLSPlaylist returns the path of the HLS rendition of the source, empty if
+// the last health check found its content protected.
+func (s *MPDStreamSource) HLSPlaylist() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.protected {
+		return ""
+	}
+	return hlsMasterPlaylist
+}
+
+func isProtected(mpdPlaylist *mpd.MPD) bool {
+	for _, period := range mpdPlaylist.Period {
+		for _, adaptationSet := range period.AdaptationSets {
+			if len(adaptationSet.ContentProtections) > 0 {
+				return true
+			}
+			for _, representation := range adaptationSet.Representations {
+				if len(representation.ContentProtections) > 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func isHLSRequest(r *http.Request) bool {
+	p := mux.Vars(r)["path"]
+	return p == hlsMasterPlaylist || strings.HasPrefix(p, hlsMediaPrefix)
+}
+
+// mediaKind returns "video", "audio" or "text".
+func mediaKind(adaptationSet *mpd.AdaptationSet, representation *mpd.Representation) string {
+	mimeType := adaptationSet.MimeType
+	if representation.MimeType != nil {
+		mimeType = *representation.MimeType
+	}
+	if kind, _, found := strings.Cut(mimeType, "/"); found && kind != "application" {
+		return kind
+	}
+	if adaptationSet.ContentType != nil {
+		return *adaptationSet.ContentType
+	}
+	return "text"
+}
+
+func codecs(adaptationSet *mpd.AdaptationSet, representation *mpd.Representation) string {
+	if representation.Codecs != nil {
+		return *representation.Codecs
+	}
+	if adaptationSet.Codecs != nil {
+		return *adaptationSet.Codecs
+	}
+	return ""
+}
+
+// hlsPeriod returns the Period served as HLS, the current one of live
+// presentations.
+func hlsPeriod(mpdPlaylist *mpd.MPD) *mpd.Period {
+	if len(mpdPlaylist.Period) == 0 {
+		return nil
+	}
+	if mpdPlaylist.Type != nil && *mpdPlaylist.Type == "dynamic" {
+		return mpdPlaylist.Period[len(mpdPlaylist.Period)-1]
+	}
+	return mpdPlaylist.Period[0]
+}
+
+func hlsMediaURI(representation *mpd.Representation) string {
+	return hlsMediaPrefix + url.PathEscape(*representation.ID) + ".m3u8"
+}
+
+// hlsMaster builds a master playlist with a variant per video representation
+// and the audio adaptation sets as renditions.
+func hlsMaster(mpdPlaylist *mpd.MPD) (*m3uparser.MasterPlaylist, error) {
+	period := hlsPeriod(mpdPlaylist)
+	if period == nil {
+		return nil, errors.New("no periods in manifest")
+	}
+
+	master := &m3uparser.MasterPlaylist{Version: 7, IndependentSegments: true}
+	audioCodecs := ""
+	var audioBandwidth int64
+	for _, adaptationSet := range period.AdaptationSets {
+		if len(adaptationSet.Representations) == 0 {
+			continue
+		}
+		representation := &adaptationSet.Representations[0]
+		if representation.ID == nil || mediaKind(adaptationSet, representation) != "audio" {
+			continue
+		}
+
+		rendition := &m3uparser.Rendition{
+			Type:       "AUDIO",
+			GroupID:    hlsAudioGroup,
+			URI:        hlsMediaURI(representation),
+			Default:    len(master.Renditions) == 0,
+			Autoselect: true,
+		}
+		if adaptationSet.Lang != nil {
+			rendition.Language = *adaptationSet.Lang
+			rendition.Name = *adaptationSet.Lang
+		}
+		if len(adaptationSet.Labels) > 0 {
+			rendition.Name = adaptationSet.Labels[0].Value
+		}
+		if rendition.Name == "" {
+			rendition.Name = "audio " + strconv.Itoa(len(master.Renditions)+1)
+		}
+		for _, configuration := range append(representation.AudioChannelConfiguration, adaptationSet.AudioChannelConfiguration...) {
+			if configuration.Value != nil {
+				rendition.Channels = *configuration.Value
+				break
+			}
+		}
+		master.Renditions = append(master.Renditions, rendition)
+
+		if len(master.Renditions) == 1 {
+			audioCodecs = codecs(adaptationSet, representation)
+			if representation.Bandwidth != nil {
+				audioBandwidth = int64(*representation.Bandwidth)
+			}
+		}
+	}
+
+	for _, adaptationSet := range period.AdaptationSets {
+		for k := range adaptationSet.Representations {
+			representation := &adaptationSet.Representations[k]
+			if representation.ID == nil || mediaKind(adaptationSet, representation) != "video" {
+				continue
+			}
+
+			variant := &m3uparser.Variant{URI: hlsMediaURI(representation)}
+			if representation.Bandwidth != nil {
+				variant.Bandwidth = int64(*representation.Bandwidth) + audioBandwidth
+			}
+			if c := codecs(adaptationSet, representation); c != "" {
+				variant.Codecs = append(variant.Codecs, c)
+			}
+			if representation.Width != nil && representation.Height != nil {
+				variant.Resolution = &m3uparser.Resolution{Width: int(*representation.Width), Height: int(*representation.Height)}
+			}
+			if representation.FrameRate != nil {
+				variant.FrameRate = parseFrameRate(*representation.FrameRate)
+			}
+			if len(master.Renditions) > 0 {
+				variant.Audio = hlsAudioGroup
+				if audioCodecs != "" {
+					variant.Codecs = append(variant.Codecs, audioCodecs)
+				}
+			}
+			master.Variants = append(master.Variants, variant)
+		}
+	}
+
+	// Audio only presentations
+	if len(master.Variants) == 0 {
+		for _, rendition := range master.Renditions {
+			variant := &m3uparser.Variant{URI: rendition.URI, Bandwidth: audioBandwidth}
+			if audioCodecs != "" {
+				variant.Codecs = []string{audioCodecs}
+			}
+			master.Variants = append(master.Variants, variant)
+		}
+		master.Renditions = nil
+	}
+
+	if len(master.Variants) == 0 {
+		return nil, errors.New("no audio or video representations")
+	}
+	return master, nil
+}
+
+// parseFrameRate parses a DASH frame rate, either a number or a fraction.
+func parseFrameRate(value string) float64 {
+	numerator, denominator, found := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
+
+// hlsByteRange converts a DASH byte range, <first>-<last>, to a HLS one.
+func hlsByteRange(value string) *m3uparser.ByteRange {
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		return nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil
+	}
+	return &m3uparser.ByteRange{Length: end - start + 1, Offset: start}
+}
+
+// hlsSegmentURI returns the proxy URI of a segment, relative to the media
+// playlists under hls/.
+func hlsSegmentURI(segmentURL string, channel string) (string, error) {
+	target, err := url.Parse(segmentURL)
+	if err != nil {
+		return "", err
+	}
+	return "../" + proxyFileURL(target, channel), nil
+}
+
+// hlsMedia builds the media playlist of the representation with the given id.
+func hlsMedia(mpdPlaylist *mpd.MPD, manifest *url.URL, id string, channel string, now time.Time) (*m3uparser.MediaPlaylist, error) {
+	live := mpdPlaylist.Type != nil && *mpdPlaylist.Type == "dynamic"
+	periods := mpdPlaylist.Period
+	if live {
+		periods = []*mpd.Period{hlsPeriod(mpdPlaylist)}
+	}
+
+	media := &m3uparser.MediaPlaylist{Version: 7, IndependentSegments: true}
+	if !live {
+		media.PlaylistType = "VOD"
+		media.EndList = true
+	}
+
+	found := false
+	targetDuration := 0.0
+	for i, period := range periods {
+		representation := findRepresentation(period, id)
+		if representation == nil {
+			continue
+		}
+		found = true
+
+		index, err := mpdPlaylist.Segments(manifest, period, representation, now)
+		if err != nil {
+			return nil, err
+		}
+
+		var initMap *m3uparser.Map
+		if index.Initialization != nil {
+			uri, err := hlsSegmentURI(index.Initialization.URL, channel)
+			if err != nil {
+				return nil, err
+			}
+			initMap = &m3uparser.Map{URI: uri, ByteRange: hlsByteRange(index.Initialization.Range)}
+		}
+
+		for k, segment := range index.Segments {
+			uri, err := hlsSegmentURI(segment.URL, channel)
+			if err != nil {
+				return nil, err
+			}
+			duration := segment.Length().Seconds()
+			targetDuration = math.Max(targetDuration, duration)
+			if len(media.Segments) == 0 {
+				media.MediaSequence = int64(segment.Number)
+			}
+			media.Segments = append(media.Segments, &m3uparser.MediaSegment{
+				URI:           uri,
+				Duration:      duration,
+				ByteRange:     hlsByteRange(segment.Range),
+				Map:           initMap,
+				Discontinuity: i > 0 && k == 0 && len(media.Segments) > 0,
+			})
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("representation %s not found", id)
+	}
+	media.TargetDuration = int(math.Ceil(targetDuration))
+	return media, nil
+}
+
+// sequenceHLSMedia maps the media sequence of a live media playlist, the
+// segment numbers of the current period, into the sequence space of the
+// channel. Periods are mapped as different upstreams, so the sequence keeps
+// increasing and a discontinuity is inserted where a period starts.
+func sequenceHLSMedia(sequencer *MediaSequencer, source string, period *mpd.Period, playlist string, media *m3uparser.MediaPlaylist) {
+	if len(media.Segments) == 0 {
+		return
+	}
+	if period.ID != nil {
+		source += "#" + *period.ID
+	}
+	window := sequencer.Map(source, playlist, mediaWindow{
+		mediaSequence:         media.MediaSequence,
+		discontinuitySequence: media.DiscontinuitySequence,
+		segments:              len(media.Segments),
+		targetDuration:        float64(media.TargetDuration),
+	})
+	media.MediaSequence = window.mediaSequence
+	media.DiscontinuitySequence = window.discontinuitySequence
+	if window.leadingDiscontinuity {
+		media.Segments[0].Discontinuity = true
+	}
+}
+
+func findRepresentation(period *mpd.Period, id string) *mpd.Representation {
+	for _, adaptationSet := range period.AdaptationSets {
+		for k := range adaptationSet.Representations {
+			representation := &adaptationSet.Representations[k]
+			if representation.ID != nil && *representation.ID == id {
+				return representation
+			}
+		}
+	}
+	return nil
+}
+
+// serveHLS serves the HLS rendition of the source's manifest.
+func (s *MPDStreamSource) serveHLS(w http.ResponseWriter, r *http.Request) error {
+	uri, err := url.Parse(s.Url())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	mpdPlaylist, err := s.fetchMPD(uri)
+	if err != nil {
+		return err
+	}
+	if isProtected(mpdPlaylist) {
+		return fmt.Errorf("%w: protected content can't be served as HLS", ErrInvalidRequest)
+	}
+	filterMPD(mpdPlaylist, s.renditionFilter(r))
+
+	var playlist m3uparser.HLSPlaylist
+	p := mux.Vars(r)["path"]
+	if p == hlsMasterPlaylist {
+		playlist, err = hlsMaster(mpdPlaylist)
+	} else {
+		var media *m3uparser.MediaPlaylist
+		media, err = hlsMedia(mpdPlaylist, uri, strings.TrimSuffix(strings.TrimPrefix(p, hlsMediaPrefix), ".m3u8"), requestChannel(r), time.Now())
+		if err == nil && !media.EndList && s.sequencer != nil {
+			sequenceHLSMedia(s.sequencer, s.Url(), hlsPeriod(mpdPlaylist), p, media)
+		}
+		playlist = media
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	_, err = playlist.WriteTo(w)
+	return err
+}
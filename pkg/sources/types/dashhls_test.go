@@ -0,0 +1,299 @@
+package types
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+)
+
+func decodeTestMPD(t *testing.T, manifest string) *mpd.MPD {
+	t.Helper()
+
+	mpdPlaylist, err := mpd.DecodeFromReader(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the manifest: %v", err)
+	}
+	return mpdPlaylist
+}
+
+func TestHLSMaster(t *testing.T) {
+	mpdPlaylist := decodeTestMPD(t, `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT60S">
+  <Period id="1">
+    <AdaptationSet mimeType="audio/mp4" codecs="mp4a.40.2" lang="en">
+      <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="2"/>
+      <Representation id="audio-en" bandwidth="128000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" codecs="mp4a.40.2" lang="pt">
+      <Label>Português</Label>
+      <Representation id="audio-pt" bandwidth="96000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="video/mp4" codecs="avc1.4d401e">
+      <Representation id="video 1" bandwidth="800000" width="640" height="360" frameRate="30000/1001"/>
+      <Representation id="video-2" bandwidth="2500000" width="1280" height="720" frameRate="25" codecs="avc1.64001f"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	master, err := hlsMaster(mpdPlaylist)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedRenditions := []m3uparser.Rendition{
+		{Type: "AUDIO", GroupID: hlsAudioGroup, URI: "hls/audio-en.m3u8", Language: "en", Name: "en", Default: true, Autoselect: true, Channels: "2"},
+		{Type: "AUDIO", GroupID: hlsAudioGroup, URI: "hls/audio-pt.m3u8", Language: "pt", Name: "Português", Autoselect: true},
+	}
+	if len(master.Renditions) != len(expectedRenditions) {
+		t.Fatalf("Unexpected number of renditions. Expected: %d, Got: %d", len(expectedRenditions), len(master.Renditions))
+	}
+	for i, expected := range expectedRenditions {
+		got := *master.Renditions[i]
+		if got.Type != expected.Type || got.GroupID != expected.GroupID || got.URI != expected.URI || got.Language != expected.Language ||
+			got.Name != expected.Name || got.Default != expected.Default || got.Autoselect != expected.Autoselect || got.Channels != expected.Channels {
+			t.Errorf("Unexpected rendition %d. Expected: %+v, Got: %+v", i, expected, got)
+		}
+	}
+
+	expectedVariants := []struct {
+		uri        string
+		bandwidth  int64
+		codecs     string
+		resolution string
+		frameRate  float64
+	}{
+		{"hls/video%201.m3u8", 928000, "avc1.4d401e,mp4a.40.2", "640x360", 29.97},
+		{"hls/video-2.m3u8", 2628000, "avc1.64001f,mp4a.40.2", "1280x720", 25},
+	}
+	if len(master.Variants) != len(expectedVariants) {
+		t.Fatalf("Unexpected number of variants. Expected: %d, Got: %d", len(expectedVariants), len(master.Variants))
+	}
+	for i, expected := range expectedVariants {
+		got := master.Variants[i]
+		resolution := ""
+		if got.Resolution != nil {
+			resolution = got.Resolution.String()
+		}
+		if got.URI != expected.uri || got.Bandwidth != expected.bandwidth || strings.Join(got.Codecs, ",") != expected.codecs ||
+			resolution != expected.resolution || got.FrameRate != expected.frameRate || got.Audio != hlsAudioGroup {
+			t.Errorf("Unexpected variant %d. Expected: %+v, Got: %+v", i, expected, got)
+		}
+	}
+}
+
+func TestHLSMaster_AudioOnly(t *testing.T) {
+	mpdPlaylist := decodeTestMPD(t, `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT60S">
+  <Period id="1">
+    <AdaptationSet contentType="audio" mimeType="application/mp4" codecs="mp4a.40.2">
+      <Representation id="radio" bandwidth="128000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	master, err := hlsMaster(mpdPlaylist)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(master.Renditions) != 0 {
+		t.Errorf("Unexpected renditions: %d", len(master.Renditions))
+	}
+	if len(master.Variants) != 1 {
+		t.Fatalf("Unexpected number of variants. Expected: 1, Got: %d", len(master.Variants))
+	}
+	if got := master.Variants[0]; got.URI != "hls/radio.m3u8" || got.Bandwidth != 128000 || strings.Join(got.Codecs, ",") != "mp4a.40.2" || got.Audio != "" {
+		t.Errorf("Unexpected variant: %+v", got)
+	}
+
+	if _, err := hlsMaster(decodeTestMPD(t, `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static"><Period id="1"/></MPD>`)); err == nil {
+		t.Errorf("Unexpected master playlist without representations")
+	}
+}
+
+func TestHLSMedia_VOD(t *testing.T) {
+	mpdPlaylist := decodeTestMPD(t, `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT8S">
+  <Period id="1" start="PT0S" duration="PT4S">
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="v1" bandwidth="500000">
+        <BaseURL>https://cdn.example.com/vod/v1.mp4</BaseURL>
+        <SegmentList timescale="1" duration="2">
+          <Initialization range="0-999"/>
+          <SegmentURL mediaRange="1000-1999"/>
+          <SegmentURL mediaRange="2000-2999"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+  <Period id="2" start="PT4S" duration="PT4S">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="1" duration="2" startNumber="1" initialization="init-$RepresentationID$.mp4" media="$RepresentationID$-$Number$.m4s"/>
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+	manifest, _ := url.Parse("https://origin.example.com/vod/manifest.mpd")
+
+	media, err := hlsMedia(mpdPlaylist, manifest, "v1", "channel1", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if media.PlaylistType != "VOD" || !media.EndList || media.TargetDuration != 2 || media.MediaSequence != 1 {
+		t.Errorf("Unexpected playlist: %+v", media)
+	}
+
+	file, _ := url.Parse("https://cdn.example.com/vod/v1.mp4")
+	fileURI := "../" + proxyFileURL(file, "channel1")
+	dir, _ := url.Parse("https://origin.example.com/vod/")
+	dirURI := "../" + proxyBaseURL(dir, "channel1")
+	expected := []struct {
+		uri           string
+		byteRange     *m3uparser.ByteRange
+		mapURI        string
+		mapRange      *m3uparser.ByteRange
+		discontinuity bool
+	}{
+		{fileURI, &m3uparser.ByteRange{Length: 1000, Offset: 1000}, fileURI, &m3uparser.ByteRange{Length: 1000, Offset: 0}, false},
+		{fileURI, &m3uparser.ByteRange{Length: 1000, Offset: 2000}, fileURI, &m3uparser.ByteRange{Length: 1000, Offset: 0}, false},
+		{dirURI + "v1-1.m4s", nil, dirURI + "init-v1.mp4", nil, true},
+		{dirURI + "v1-2.m4s", nil, dirURI + "init-v1.mp4", nil, false},
+	}
+	if len(media.Segments) != len(expected) {
+		t.Fatalf("Unexpected number of segments. Expected: %d, Got: %d", len(expected), len(media.Segments))
+	}
+	for i, segment := range media.Segments {
+		if segment.URI != expected[i].uri || segment.Duration != 2 || segment.Discontinuity != expected[i].discontinuity ||
+			!equalByteRange(segment.ByteRange, expected[i].byteRange) {
+			t.Errorf("Unexpected segment %d. Expected: %+v, Got: %+v", i, expected[i], segment)
+		}
+		if segment.Map == nil || segment.Map.URI != expected[i].mapURI || !equalByteRange(segment.Map.ByteRange, expected[i].mapRange) {
+			t.Errorf("Unexpected map of segment %d. Expected: %s %v, Got: %+v", i, expected[i].mapURI, expected[i].mapRange, segment.Map)
+		}
+	}
+
+	if _, err := hlsMedia(mpdPlaylist, manifest, "v2", "channel1", time.Now()); err == nil {
+		t.Errorf("Unexpected media playlist of a missing representation")
+	}
+}
+
+func equalByteRange(a, b *m3uparser.ByteRange) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// liveTestMPD has a segment every 2 seconds numbered from the start of each
+// period, the second one starts 60 seconds after the first.
+const liveTestMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic" availabilityStartTime="2024-01-01T00:00:00Z" timeShiftBufferDepth="PT10S">
+  <Period id="p1" start="PT0S">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="1" duration="2" startNumber="1" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s"/>
+      <Representation id="v1" bandwidth="500000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestHLSMedia_Live(t *testing.T) {
+	mpdPlaylist := decodeTestMPD(t, liveTestMPD)
+	manifest, _ := url.Parse("https://origin.example.com/live/manifest.mpd")
+
+	// 61s after the start, segments 26 to 30 are in the time shift buffer
+	now := time.Date(2024, 1, 1, 0, 1, 1, 0, time.UTC)
+	media, err := hlsMedia(mpdPlaylist, manifest, "v1", "channel1", now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if media.PlaylistType != "" || media.EndList || media.TargetDuration != 2 || media.MediaSequence != 26 {
+		t.Errorf("Unexpected playlist: %+v", media)
+	}
+	if len(media.Segments) != 5 {
+		t.Fatalf("Unexpected number of segments. Expected: 5, Got: %d", len(media.Segments))
+	}
+	dir, _ := url.Parse("https://origin.example.com/live/v1/")
+	if expected := "../" + proxyBaseURL(dir, "channel1") + "26.m4s"; media.Segments[0].URI != expected {
+		t.Errorf("Unexpected segment. Expected: %s, Got: %s", expected, media.Segments[0].URI)
+	}
+}
+
+func TestSequenceHLSMedia(t *testing.T) {
+	sequencer := NewMediaSequencer()
+	manifest, _ := url.Parse("https://origin.example.com/live/manifest.mpd")
+	p2 := strings.Replace(liveTestMPD, `<Period id="p1" start="PT0S">`, `<Period id="p2" start="PT60S">`, 1)
+
+	tests := []struct {
+		name          string
+		manifest      string
+		now           time.Time
+		mediaSequence int64
+		discSequence  int64
+		discontinuity bool
+	}{
+		{"first period", liveTestMPD, time.Date(2024, 1, 1, 0, 0, 51, 0, time.UTC), 21, 0, false},
+		{"first period advancing", liveTestMPD, time.Date(2024, 1, 1, 0, 0, 53, 0, time.UTC), 22, 0, false},
+		// The second period restarts the numbering at 1
+		{"second period", p2, time.Date(2024, 1, 1, 0, 1, 3, 0, time.UTC), 27, 0, true},
+		{"second period advancing", p2, time.Date(2024, 1, 1, 0, 1, 5, 0, time.UTC), 27, 0, true},
+		{"first segment of the period left", p2, time.Date(2024, 1, 1, 0, 1, 13, 0, time.UTC), 28, 1, false},
+	}
+
+	for _, tt := range tests {
+		mpdPlaylist := decodeTestMPD(t, tt.manifest)
+		media, err := hlsMedia(mpdPlaylist, manifest, "v1", "channel1", tt.now)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", tt.name, err)
+		}
+		sequenceHLSMedia(sequencer, "source1", hlsPeriod(mpdPlaylist), "hls/v1.m3u8", media)
+		if media.MediaSequence != tt.mediaSequence || media.DiscontinuitySequence != tt.discSequence || media.Segments[0].Discontinuity != tt.discontinuity {
+			t.Errorf("%s: Unexpected sequence. Expected: %d %d %v, Got: %d %d %v", tt.name, tt.mediaSequence, tt.discSequence, tt.discontinuity,
+				media.MediaSequence, media.DiscontinuitySequence, media.Segments[0].Discontinuity)
+		}
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+	}{
+		{"25", 25},
+		{"29.97", 29.97},
+		{"30000/1001", 29.97},
+		{"60000/1001", 59.94},
+		{"50/2", 25},
+		{"25/0", 0},
+		{"abc", 0},
+		{"30/abc", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseFrameRate(tt.value); got != tt.expected {
+			t.Errorf("Unexpected frame rate of %s. Expected: %v, Got: %v", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestHLSByteRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected *m3uparser.ByteRange
+	}{
+		{"0-999", &m3uparser.ByteRange{Length: 1000, Offset: 0}},
+		{"1000-1000", &m3uparser.ByteRange{Length: 1, Offset: 1000}},
+		{"1000-999", nil},
+		{"1000", nil},
+		{"a-b", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := hlsByteRange(tt.value); !equalByteRange(got, tt.expected) {
+			t.Errorf("Unexpected byte range of %s. Expected: %v, Got: %v", tt.value, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+)
+
+// RenditionFilter limits the variants of master playlists and the
+// representations of MPDs served to clients, zero values don't filter.
+// Codecs are the allowed codecs, matched on their prefix, e.g. avc1 or mp4a.
+// SingleVariant keeps only the best variant left by the other limits.
+// Languages are the preferred audio and subtitle languages, in order, and
+// OnlyLanguages drops the renditions in other languages.
+type RenditionFilter struct {
+	MaxResolution string   `json:"max_resolution,omitempty"` // <width>x<height>
+	MaxBandwidth  int64    `json:"max_bandwidth,omitempty"`  // bits per second
+	Codecs        []string `json:"codecs,omitempty"`
+	SingleVariant bool     `json:"single_variant,omitempty"`
+	Languages     []string `json:"languages,omitempty"`
+	OnlyLanguages bool     `json:"only_languages,omitempty"`
+	noCodecs      bool     // merged codec limits allowing none
+}
+
+type renditionFilterKey struct{}
+
+// WithRenditionFilter adds a filter to the request, applied on top of the
+// source's own filter, such as the requesting user's preferences.
+func WithRenditionFilter(r *http.Request, filter RenditionFilter) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), renditionFilterKey{}, filter))
+}
+
+// renditionFilter returns the source's filter combined with the request's.
+func (s *BaseStreamSource) renditionFilter(r *http.Request) RenditionFilter {
+	filter, _ := r.Context().Value(renditionFilterKey{}).(RenditionFilter)
+	return s.filter.merge(filter)
+}
+
+// parseOption sets a filter option from a M3UPROXYFILTER tag value, e.g.
+// max_resolution=1280x720.
+func (f *RenditionFilter) parseOption(value string) {
+	key, value, _ := strings.Cut(value, "=")
+	switch key {
+	case "max_resolution":
+		f.MaxResolution = value
+	case "max_bandwidth":
+		f.MaxBandwidth, _ = strconv.ParseInt(value, 10, 64)
+	case "codecs":
+		f.Codecs = strings.Split(value, ",")
+	case "single_variant":
+		f.SingleVariant = true
+	case "languages":
+		f.Languages = strings.Split(value, ",")
+	case "only_languages":
+		f.OnlyLanguages = true
+	}
+}
+
+func (f RenditionFilter) empty() bool {
+	return f.MaxResolution == "" && f.MaxBandwidth == 0 && len(f.Codecs) == 0 && !f.noCodecs && !f.SingleVariant && len(f.Languages) == 0
+}
+
+// cacheKey identifies the filter in manifest cache keys.
+func (f RenditionFilter) cacheKey() string {
+	if f.empty() {
+		return ""
+	}
+	return fmt.Sprintf("#%s/%d/%s/%t/%t/%s/%t", f.MaxResolution, f.MaxBandwidth, strings.Join(f.Codecs, ","), f.noCodecs, f.SingleVariant,
+		strings.Join(f.Languages, ","), f.OnlyLanguages)
+}
+
+// merge returns the stricter combination of both filters, the other's
+// languages replace the filter's.
+func (f RenditionFilter) merge(other RenditionFilter) RenditionFilter {
+	result := f
+	if width, height, ok := other.maxResolution(); ok {
+		if w, h, ok := f.maxResolution(); ok {
+			width, height = min(width, w), min(height, h)
+		}
+		result.MaxResolution = fmt.Sprintf("%dx%d", width, height)
+	}
+	if other.MaxBandwidth > 0 && (result.MaxBandwidth == 0 || other.MaxBandwidth < result.MaxBandwidth) {
+		result.MaxBandwidth = other.MaxBandwidth
+	}
+	switch {
+	case other.noCodecs:
+		result.Codecs, result.noCodecs = nil, true
+	case len(other.Codecs) == 0 || result.noCodecs:
+	case len(result.Codecs) == 0:
+		result.Codecs = other.Codecs
+	default:
+		result.Codecs = intersectCodecs(result.Codecs, other.Codecs)
+		result.noCodecs = len(result.Codecs) == 0
+	}
+	result.SingleVariant = result.SingleVariant || other.SingleVariant
+	if len(other.Languages) > 0 {
+		result.Languages = other.Languages
+	}
+	result.OnlyLanguages = result.OnlyLanguages || other.OnlyLanguages
+	return result
+}
+
+// intersectCodecs returns the prefixes matching the codecs allowed by both
+// lists, the longer of each pair of related prefixes.
+func intersectCodecs(a, b []string) []string {
+	var result []string
+	for _, x := range a {
+		x = strings.ToLower(strings.TrimSpace(x))
+		for _, y := range b {
+			y = strings.ToLower(strings.TrimSpace(y))
+			codec := x
+			if strings.HasPrefix(y, x) {
+				codec = y
+			} else if !strings.HasPrefix(x, y) {
+				continue
+			}
+			if !slices.Contains(result, codec) {
+				result = append(result, codec)
+			}
+		}
+	}
+	return result
+}
+
+func (f RenditionFilter) maxResolution() (int, int, bool) {
+	width, height, found := strings.Cut(f.MaxResolution, "x")
+	if !found {
+		return 0, 0, false
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil {
+		return 0, 0, false
+	}
+	h, err := strconv.Atoi(height)
+	if err != nil {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// allows tells if a rendition is within the limits, width and height are 0
+// and codecs empty when unknown.
+func (f RenditionFilter) allows(bandwidth int64, width, height int, codecs []string) bool {
+	if f.MaxBandwidth > 0 && bandwidth > f.MaxBandwidth {
+		return false
+	}
+	if maxWidth, maxHeight, ok := f.maxResolution(); ok && (width > maxWidth || height > maxHeight) {
+		return false
+	}
+	if f.noCodecs {
+		return len(codecs) == 0
+	}
+	if len(f.Codecs) == 0 {
+		return true
+	}
+	for _, codec := range codecs {
+		codec = strings.TrimSpace(codec)
+		allowed := slices.ContainsFunc(f.Codecs, func(prefix string) bool {
+			return strings.HasPrefix(strings.ToLower(codec), strings.ToLower(strings.TrimSpace(prefix)))
+		})
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+type variantLine struct {
+	line      int
+	iframe    bool
+	bandwidth int64
+	keep      bool
+}
+
+// filterMasterPlaylist drops the variants of a master playlist the filter
+// excludes, with their URI lines. If none is left the one with the lowest
+// bandwidth is kept, so the channel still plays. Media playlists are
+// returned unchanged.
+func filterMasterPlaylist(body []byte, filter RenditionFilter) []byte {
+	if filter.empty() || !bytes.Contains(body, []byte("#EXT-X-STREAM-INF")) {
+		return body
+	}
+
+	lines := readLines(body)
+
+	var variants []*variantLine
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		iframe := strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:")
+		if !iframe && !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			continue
+		}
+
+		attrs := m3uparser.ParseHLSAttributes(line[strings.Index(line, ":")+1:])
+		variant := &variantLine{line: i, iframe: iframe}
+		variant.bandwidth, _ = strconv.ParseInt(attrs.GetValue("BANDWIDTH"), 10, 64)
+		width, height := 0, 0
+		if resolution, ok := attrs.Get("RESOLUTION"); ok {
+			w, h, _ := strings.Cut(resolution, "x")
+			width, _ = strconv.Atoi(w)
+			height, _ = strconv.Atoi(h)
+		}
+		var codecs []string
+		if value, ok := attrs.Get("CODECS"); ok {
+			codecs = strings.Split(value, ",")
+		}
+		variant.keep = filter.allows(variant.bandwidth, width, height, codecs)
+		variants = append(variants, variant)
+	}
+
+	selectVariants(variants, filter.SingleVariant)
+
+	dropped := make(map[int]bool)
+	for _, variant := range variants {
+		if variant.keep {
+			continue
+		}
+		dropped[variant.line] = true
+		if variant.iframe {
+			continue
+		}
+		// The URI line follows the tag
+		for next := variant.line + 1; next < len(lines); next++ {
+			text := strings.TrimSpace(lines[next])
+			if text != "" && !strings.HasPrefix(text, "#") {
+				dropped[next] = true
+				break
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if !dropped[i] {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func readLines(body []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxManifestSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// selectVariants keeps the lowest bandwidth variant when all were excluded,
+// and only the highest bandwidth one in single variant mode, dropping
+// I-frame variants.
+func selectVariants(variants []*variantLine, single bool) {
+	var best, lowest *variantLine
+	kept := false
+	for _, variant := range variants {
+		if variant.iframe {
+			variant.keep = variant.keep && !single
+			continue
+		}
+		if lowest == nil || variant.bandwidth < lowest.bandwidth {
+			lowest = variant
+		}
+		if variant.keep {
+			kept = true
+			if best == nil || variant.bandwidth > best.bandwidth {
+				best = variant
+			}
+		}
+	}
+
+	if !kept {
+		if lowest != nil {
+			lowest.keep = true
+		}
+		return
+	}
+
+	if single {
+		for _, variant := range variants {
+			variant.keep = variant == best
+		}
+	}
+}
+
+func representationCodecs(adaptationSet *mpd.AdaptationSet, representation *mpd.Representation) []string {
+	if c := codecs(adaptationSet, representation); c != "" {
+		return strings.Split(c, ",")
+	}
+	return nil
+}
+
+// filterMPD drops the representations of a MPD the filter excludes, and the
+// adaptation sets left empty. When nothing of a media kind is left, the
+// representation with the lowest bandwidth is kept. Only codecs are checked
+// for audio and text representations.
+func filterMPD(mpdPlaylist *mpd.MPD, filter RenditionFilter) {
+	if filter.empty() {
+		return
+	}
+
+	type lowestRepresentation struct {
+		adaptationSet  *mpd.AdaptationSet
+		representation mpd.Representation
+		left           bool // Some representation of the kind is kept
+	}
+
+	codecsOnly := RenditionFilter{Codecs: filter.Codecs, noCodecs: filter.noCodecs}
+	for _, period := range mpdPlaylist.Period {
+		lowest := make(map[string]*lowestRepresentation)
+		kept := make(map[*mpd.AdaptationSet][]mpd.Representation)
+		for _, adaptationSet := range period.AdaptationSets {
+			for k := range adaptationSet.Representations {
+				representation := &adaptationSet.Representations[k]
+				kind := mediaKind(adaptationSet, representation)
+				bandwidth := int64(valueOr(representation.Bandwidth, 0))
+				if l, ok := lowest[kind]; !ok || bandwidth < int64(valueOr(l.representation.Bandwidth, 0)) {
+					lowest[kind] = &lowestRepresentation{adaptationSet, *representation, ok && l.left}
+				}
+
+				codecs := representationCodecs(adaptationSet, representation)
+				allowed := codecsOnly.allows(bandwidth, 0, 0, codecs)
+				if kind == "video" {
+					width := int(valueOr(representation.Width, 0))
+					height := int(valueOr(representation.Height, 0))
+					allowed = filter.allows(bandwidth, width, height, codecs)
+				}
+				if allowed {
+					kept[adaptationSet] = append(kept[adaptationSet], *representation)
+					lowest[kind].left = true
+				}
+			}
+		}
+
+		for _, l := range lowest {
+			if !l.left {
+				kept[l.adaptationSet] = append(kept[l.adaptationSet], l.representation)
+			}
+		}
+
+		adaptationSets := make([]*mpd.AdaptationSet, 0, len(period.AdaptationSets))
+		for _, adaptationSet := range period.AdaptationSets {
+			if len(adaptationSet.Representations) > 0 {
+				if len(kept[adaptationSet]) == 0 {
+					continue
+				}
+				adaptationSet.Representations = kept[adaptationSet]
+			}
+			adaptationSets = append(adaptationSets, adaptationSet)
+		}
+		period.AdaptationSets = adaptationSets
+
+		if filter.SingleVariant {
+			keepBestVideo(period)
+		}
+		if len(filter.Languages) > 0 {
+			selectAdaptationSets(period, filter.Languages, filter.OnlyLanguages)
+		}
+	}
+}
+
+func hasVideo(adaptationSet *mpd.AdaptationSet) bool {
+	for k := range adaptationSet.Representations {
+		if mediaKind(adaptationSet, &adaptationSet.Representations[k]) == "video" {
+			return true
+		}
+	}
+	return false
+}
+
+// keepBestVideo keeps only the video representation with the highest
+// bandwidth of a period.
+func keepBestVideo(period *mpd.Period) {
+	bestSet, best := -1, -1
+	var bestBandwidth uint64
+	for i, adaptationSet := range period.AdaptationSets {
+		for k := range adaptationSet.Representations {
+			representation := &adaptationSet.Representations[k]
+			if mediaKind(adaptationSet, representation) != "video" {
+				continue
+			}
+			if bestSet < 0 || valueOr(representation.Bandwidth, 0) > bestBandwidth {
+				bestSet, best, bestBandwidth = i, k, valueOr(representation.Bandwidth, 0)
+			}
+		}
+	}
+	if bestSet < 0 {
+		return
+	}
+
+	adaptationSets := make([]*mpd.AdaptationSet, 0, len(period.AdaptationSets))
+	for i, adaptationSet := range period.AdaptationSets {
+		if i == bestSet {
+			adaptationSet.Representations = []mpd.Representation{adaptationSet.Representations[best]}
+		} else if hasVideo(adaptationSet) {
+			continue
+		}
+		adaptationSets = append(adaptationSets, adaptationSet)
+	}
+	period.AdaptationSets = adaptationSets
+}
+
+func valueOr[T any](value *T, fallback T) T {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
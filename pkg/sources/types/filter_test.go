@@ -0,0 +1,215 @@
+package types
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+)
+
+func TestRenditionFilterMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   RenditionFilter
+		other    RenditionFilter
+		expected RenditionFilter
+		allowed  [][]string
+		rejected [][]string
+	}{
+		{
+			name:     "narrower codec",
+			filter:   RenditionFilter{Codecs: []string{"avc1", "mp4a"}},
+			other:    RenditionFilter{Codecs: []string{"avc1.64"}},
+			expected: RenditionFilter{Codecs: []string{"avc1.64"}},
+			allowed:  [][]string{{"avc1.640028"}, nil},
+			rejected: [][]string{{"avc1.4d401f"}, {"avc1.640028", "mp4a.40.2"}, {"hvc1.1.6.L120"}},
+		},
+		{
+			name:     "narrower codec first",
+			filter:   RenditionFilter{Codecs: []string{"AVC1.64"}},
+			other:    RenditionFilter{Codecs: []string{"avc1", "mp4a"}},
+			expected: RenditionFilter{Codecs: []string{"avc1.64"}},
+			allowed:  [][]string{{"avc1.640028"}},
+			rejected: [][]string{{"avc1.4d401f"}},
+		},
+		{
+			name:     "disjoint codecs",
+			filter:   RenditionFilter{Codecs: []string{"avc1"}},
+			other:    RenditionFilter{Codecs: []string{"hvc1"}},
+			expected: RenditionFilter{noCodecs: true},
+			allowed:  [][]string{nil},
+			rejected: [][]string{{"avc1.640028"}, {"hvc1.1.6.L120"}},
+		},
+		{
+			name:     "disjoint codecs merged again",
+			filter:   RenditionFilter{noCodecs: true},
+			other:    RenditionFilter{Codecs: []string{"avc1"}},
+			expected: RenditionFilter{noCodecs: true},
+			rejected: [][]string{{"avc1.640028"}},
+		},
+		{
+			name:     "codecs from other",
+			filter:   RenditionFilter{},
+			other:    RenditionFilter{Codecs: []string{"mp4a"}},
+			expected: RenditionFilter{Codecs: []string{"mp4a"}},
+			allowed:  [][]string{{"mp4a.40.2"}},
+			rejected: [][]string{{"ec-3"}},
+		},
+		{
+			name:     "stricter limits",
+			filter:   RenditionFilter{MaxResolution: "1920x720", MaxBandwidth: 3000000, Languages: []string{"en"}},
+			other:    RenditionFilter{MaxResolution: "1280x1080", MaxBandwidth: 5000000, SingleVariant: true, Languages: []string{"pt", "en"}, OnlyLanguages: true},
+			expected: RenditionFilter{MaxResolution: "1280x720", MaxBandwidth: 3000000, SingleVariant: true, Languages: []string{"pt", "en"}, OnlyLanguages: true},
+		},
+		{
+			name:     "limits kept",
+			filter:   RenditionFilter{MaxResolution: "1280x720", MaxBandwidth: 3000000, Languages: []string{"en"}},
+			other:    RenditionFilter{},
+			expected: RenditionFilter{MaxResolution: "1280x720", MaxBandwidth: 3000000, Languages: []string{"en"}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.filter.merge(tt.other)
+		if got.cacheKey() != tt.expected.cacheKey() {
+			t.Errorf("%s: Unexpected filter. Expected: %+v, Got: %+v", tt.name, tt.expected, got)
+		}
+		for _, codecs := range tt.allowed {
+			if !got.allows(0, 0, 0, codecs) {
+				t.Errorf("%s: Unexpected rejection of %v", tt.name, codecs)
+			}
+		}
+		for _, codecs := range tt.rejected {
+			if got.allows(0, 0, 0, codecs) {
+				t.Errorf("%s: Unexpected acceptance of %v", tt.name, codecs)
+			}
+		}
+	}
+}
+
+func TestFilterMasterPlaylist(t *testing.T) {
+	playlist := []byte(`#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2"
+mid.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080,CODECS="hvc1.1.6.L120,mp4a.40.2"
+high.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=200000,URI="iframe.m3u8"
+`)
+	all := []string{"low.m3u8", "mid.m3u8", "high.m3u8", "iframe.m3u8"}
+
+	tests := []struct {
+		name     string
+		filter   RenditionFilter
+		expected []string
+	}{
+		{"no filter", RenditionFilter{}, all},
+		{"max resolution", RenditionFilter{MaxResolution: "1280x720"}, []string{"low.m3u8", "mid.m3u8", "iframe.m3u8"}},
+		{"max bandwidth", RenditionFilter{MaxBandwidth: 1000000}, []string{"low.m3u8", "iframe.m3u8"}},
+		{"codecs", RenditionFilter{Codecs: []string{"avc1", "mp4a"}}, []string{"low.m3u8", "mid.m3u8", "iframe.m3u8"}},
+		{"nothing left keeps the lowest", RenditionFilter{Codecs: []string{"hvc1", "mp4a"}, MaxBandwidth: 1000000}, []string{"low.m3u8", "iframe.m3u8"}},
+		{"single variant", RenditionFilter{SingleVariant: true}, []string{"high.m3u8"}},
+		{"single variant within limits", RenditionFilter{SingleVariant: true, Codecs: []string{"avc1", "mp4a"}}, []string{"mid.m3u8"}},
+	}
+
+	for _, tt := range tests {
+		got := string(filterMasterPlaylist(playlist, tt.filter))
+		for _, uri := range all {
+			if kept := strings.Contains(got, uri); kept != slices.Contains(tt.expected, uri) {
+				t.Errorf("%s: Unexpected variant %s. Expected kept: %v, Got:\n%s", tt.name, uri, !kept, got)
+			}
+		}
+		if !strings.HasPrefix(got, "#EXTM3U\n#EXT-X-INDEPENDENT-SEGMENTS\n") {
+			t.Errorf("%s: Unexpected header:\n%s", tt.name, got)
+		}
+	}
+
+	media := []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\nseg.ts\n")
+	if got := filterMasterPlaylist(media, RenditionFilter{MaxBandwidth: 1}); string(got) != string(media) {
+		t.Errorf("Unexpected change of a media playlist:\n%s", got)
+	}
+}
+
+const filterTestMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT60S">
+  <Period id="1">
+    <AdaptationSet id="1" mimeType="video/mp4">
+      <Representation id="v360" bandwidth="800000" width="640" height="360" codecs="avc1.4d401e"/>
+      <Representation id="v720" bandwidth="2500000" width="1280" height="720" codecs="avc1.64001f"/>
+    </AdaptationSet>
+    <AdaptationSet id="2" mimeType="video/mp4">
+      <Representation id="v1080" bandwidth="6000000" width="1920" height="1080" codecs="hvc1.1.6.L120"/>
+    </AdaptationSet>
+    <AdaptationSet id="3" mimeType="audio/mp4" codecs="mp4a.40.2" lang="en">
+      <Representation id="aac" bandwidth="128000"/>
+    </AdaptationSet>
+    <AdaptationSet id="4" mimeType="audio/mp4" codecs="ec-3" lang="en">
+      <Representation id="eac3" bandwidth="384000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func representationIDs(period *mpd.Period) []string {
+	var ids []string
+	for _, adaptationSet := range period.AdaptationSets {
+		for _, representation := range adaptationSet.Representations {
+			ids = append(ids, valueOr(representation.ID, ""))
+		}
+	}
+	return ids
+}
+
+func TestFilterMPD(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   RenditionFilter
+		expected []string
+	}{
+		{"no filter", RenditionFilter{}, []string{"v360", "v720", "v1080", "aac", "eac3"}},
+		{"max resolution", RenditionFilter{MaxResolution: "1280x720"}, []string{"v360", "v720", "aac", "eac3"}},
+		{"max bandwidth applies to video", RenditionFilter{MaxBandwidth: 1000000}, []string{"v360", "aac", "eac3"}},
+		{"codecs", RenditionFilter{Codecs: []string{"avc1", "mp4a"}}, []string{"v360", "v720", "aac"}},
+		{"nothing left keeps the lowest of each kind", RenditionFilter{Codecs: []string{"hvc1"}}, []string{"v1080", "aac"}},
+		{"nothing left keeps the lowest video", RenditionFilter{Codecs: []string{"vp09", "mp4a"}}, []string{"v360", "aac"}},
+		{"single variant", RenditionFilter{SingleVariant: true}, []string{"v1080", "aac", "eac3"}},
+		{"single variant within limits", RenditionFilter{SingleVariant: true, MaxBandwidth: 3000000}, []string{"v720", "aac", "eac3"}},
+	}
+
+	for _, tt := range tests {
+		mpdPlaylist, err := mpd.DecodeFromReader(strings.NewReader(filterTestMPD))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		filterMPD(mpdPlaylist, tt.filter)
+		if got := representationIDs(mpdPlaylist.Period[0]); !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: Unexpected representations. Expected: %v, Got: %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestKeepBestVideo(t *testing.T) {
+	mpdPlaylist, err := mpd.DecodeFromReader(strings.NewReader(filterTestMPD))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	period := mpdPlaylist.Period[0]
+	keepBestVideo(period)
+
+	expected := []string{"v1080", "aac", "eac3"}
+	if got := representationIDs(period); !slices.Equal(got, expected) {
+		t.Errorf("Unexpected representations. Expected: %v, Got: %v", expected, got)
+	}
+	if len(period.AdaptationSets) != 3 || valueOr(period.AdaptationSets[0].ID, "") != "2" {
+		t.Errorf("Unexpected adaptation sets: %d", len(period.AdaptationSets))
+	}
+
+	// Without video the period is unchanged
+	audioOnly := &mpd.Period{AdaptationSets: period.AdaptationSets[1:]}
+	keepBestVideo(audioOnly)
+	if got := representationIDs(audioOnly); !slices.Equal(got, []string{"aac", "eac3"}) {
+		t.Errorf("Unexpected representations. Expected: [aac eac3], Got: %v", got)
+	}
+}
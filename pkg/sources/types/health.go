@@ -0,0 +1,216 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+)
+
+// Segments are read up to this size by health checks, single file
+// representations would be downloaded whole otherwise.
+const maxProbeSize = 8 * 1024 * 1024
+
+// Bytes read from continuous streams by health checks.
+const continuousProbeSize = 32 * 1024
+
+// HealthStats are the measures of the last health check of a source.
+// Bitrate is computed from the segment size and duration, or measured while
+// reading continuous streams.
+type HealthStats struct {
+	CheckedAt   time.Time `json:"checked_at"`
+	SegmentURL  string    `json:"segment_url,omitempty"`
+	LatencyMs   int64     `json:"latency_ms,omitempty"` // until the response headers
+	SegmentSize int64     `json:"segment_size,omitempty"`
+	Bitrate     int64     `json:"bitrate,omitempty"` // bits per second
+	Error       string    `json:"error,omitempty"`
+}
+
+// liveProgress is the newest segment of a live stream found by a health
+// check, the manifest is polled again once the next segment is due.
+type liveProgress struct {
+	marker  string
+	poll    func() (string, error)
+	wait    time.Duration
+	due     time.Time
+	retried bool
+}
+
+// expectProgress makes the next progress check poll the live manifest after
+// about one segment duration.
+func (s *BaseStreamSource) expectProgress(marker string, segmentDuration time.Duration, poll func() (string, error)) {
+	wait := max(segmentDuration, time.Second)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.progress = &liveProgress{marker: marker, poll: poll, wait: wait, due: time.Now().Add(wait)}
+}
+
+// ProgressDue returns when the live manifest found by the last health check
+// must be polled, false if there's nothing to poll.
+func (s *BaseStreamSource) ProgressDue() (time.Time, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.progress == nil {
+		return time.Time{}, false
+	}
+	return s.progress.due, true
+}
+
+// CheckProgress polls the live manifest found by the last health check. If
+// its newest segment didn't change it is polled once more after half a
+// segment duration, then the source fails.
+func (s *BaseStreamSource) CheckProgress() error {
+	s.mux.RLock()
+	progress := s.progress
+	s.mux.RUnlock()
+	if progress == nil {
+		return nil
+	}
+
+	next, err := progress.poll()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.progress != progress {
+		// Checked again meanwhile
+		return nil
+	}
+	if err == nil && next != progress.marker {
+		s.progress = nil
+		return nil
+	}
+	if err == nil && !progress.retried {
+		progress.retried = true
+		progress.due = time.Now().Add(progress.wait / 2)
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("live stream not advancing, newest segment still %s", progress.marker)
+	}
+	s.progress = nil
+	s.active = false
+	s.health.Error = err.Error()
+	return err
+}
+
+// mediaSegments is what health checks read from a media playlist.
+type mediaSegments struct {
+	segments       []mediaSegment
+	mediaSequence  int64
+	skipped        int64
+	targetDuration float64
+	endList        bool
+}
+
+type mediaSegment struct {
+	uri      string
+	duration float64
+}
+
+// scanMediaSegments reads the segments of a media playlist leniently, as
+// players do: the tags health checks don't use are ignored, and so are
+// malformed values.
+func scanMediaSegments(body []byte) mediaSegments {
+	var playlist mediaSegments
+	var duration float64
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// The duration may be followed by attributes before the title
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if fields := strings.Fields(value); len(fields) > 0 {
+				duration, _ = strconv.ParseFloat(fields[0], 64)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			playlist.mediaSequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			playlist.targetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-SKIP:"):
+			playlist.skipped, _ = strconv.ParseInt(m3uparser.ParseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-SKIP:")).GetValue("SKIPPED-SEGMENTS"), 10, 64)
+		case line == "#EXT-X-ENDLIST":
+			playlist.endList = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			playlist.segments = append(playlist.segments, mediaSegment{uri: line, duration: duration})
+			duration = 0
+		}
+	}
+	return playlist
+}
+
+// newest returns the sequence number and URI of the newest segment, media
+// sequences don't move while segments are appended to EVENT playlists.
+func (p mediaSegments) newest() string {
+	if len(p.segments) == 0 {
+		return ""
+	}
+	sequence := p.mediaSequence + p.skipped + int64(len(p.segments)) - 1
+	return strconv.FormatInt(sequence, 10) + " " + p.segments[len(p.segments)-1].uri
+}
+
+// probeSegment downloads a segment, measuring the upstream latency, its size
+// and bitrate.
+func (s *BaseStreamSource) probeSegment(segmentURI string, duration time.Duration, stats *HealthStats) error {
+	stats.SegmentURL = segmentURI
+
+	start := time.Now()
+	s.mux.RLock()
+	stream, err := s.conn.Stream("GET", segmentURI)
+	s.mux.RUnlock()
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", segmentURI, err)
+	}
+	defer stream.Close()
+	stats.LatencyMs = time.Since(start).Milliseconds()
+
+	size, err := io.Copy(io.Discard, io.LimitReader(stream, maxProbeSize))
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", segmentURI, err)
+	}
+	if size == 0 {
+		return fmt.Errorf("segment %s: empty", segmentURI)
+	}
+	stats.SegmentSize = size
+	if duration > 0 && size < maxProbeSize {
+		stats.Bitrate = int64(float64(size*8) / duration.Seconds())
+	}
+	return nil
+}
+
+// probeContinuous reads the start of a continuous stream, measuring its
+// bitrate.
+func probeContinuous(stream io.Reader, start time.Time, stats *HealthStats) error {
+	stats.LatencyMs = time.Since(start).Milliseconds()
+	reading := time.Now()
+	size, err := io.ReadFull(stream, make([]byte, continuousProbeSize))
+	if size == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("empty stream")
+		}
+		return err
+	}
+	stats.SegmentSize = int64(size)
+	if elapsed := time.Since(reading); elapsed > 0 {
+		stats.Bitrate = int64(float64(size*8) / elapsed.Seconds())
+	}
+	return nil
+}
+
+func (s *BaseStreamSource) healthStats() *HealthStats {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.health.CheckedAt.IsZero() {
+		return nil
+	}
+	stats := s.health
+	return &stats
+}
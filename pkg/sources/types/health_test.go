@@ -0,0 +1,115 @@
+package types
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanMediaSegments(t *testing.T) {
+	body := []byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000+0000
+#EXTINF:6.006,
+seg100.ts
+#EXTINF:abc
+seg101.ts
+#EXT-X-UNKNOWN-TAG:whatever
+#EXTINF:5.5 tvg-id="x",title
+seg102.ts
+`)
+
+	playlist := scanMediaSegments(body)
+	if len(playlist.segments) != 3 {
+		t.Fatalf("Unexpected number of segments. Expected: 3, Got: %d", len(playlist.segments))
+	}
+	if playlist.targetDuration != 6 || playlist.mediaSequence != 100 || playlist.endList {
+		t.Errorf("Unexpected playlist: %+v", playlist)
+	}
+
+	expected := []mediaSegment{{"seg100.ts", 6.006}, {"seg101.ts", 0}, {"seg102.ts", 5.5}}
+	for i, segment := range expected {
+		if playlist.segments[i] != segment {
+			t.Errorf("Unexpected segment %d. Expected: %v, Got: %v", i, segment, playlist.segments[i])
+		}
+	}
+	if newest := playlist.newest(); newest != "102 seg102.ts" {
+		t.Errorf("Unexpected newest segment. Expected: 102 seg102.ts, Got: %s", newest)
+	}
+
+	if playlist := scanMediaSegments([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n")); len(playlist.segments) != 0 || playlist.newest() != "" {
+		t.Errorf("Unexpected segments in an empty playlist: %+v", playlist)
+	}
+}
+
+func TestCheckProgress(t *testing.T) {
+	unreachable := errors.New("unreachable")
+
+	tests := []struct {
+		name     string
+		polls    []string // newest segments polled, "" failing
+		healthy  bool
+		expected string
+	}{
+		{"advancing", []string{"101 seg101.ts"}, true, ""},
+		{"advancing when polled again", []string{"100 seg100.ts", "101 seg101.ts"}, true, ""},
+		{"not advancing", []string{"100 seg100.ts", "100 seg100.ts"}, false, "live stream not advancing, newest segment still 100 seg100.ts"},
+		{"poll error", []string{""}, false, unreachable.Error()},
+	}
+
+	for _, tt := range tests {
+		s := &BaseStreamSource{active: true, mux: &sync.RWMutex{}}
+		polls := tt.polls
+		s.expectProgress("100 seg100.ts", 6*time.Second, func() (string, error) {
+			next := polls[0]
+			polls = polls[1:]
+			if next == "" {
+				return "", unreachable
+			}
+			return next, nil
+		})
+
+		start := time.Now()
+		if due, ok := s.ProgressDue(); !ok || due.Before(start.Add(5*time.Second)) {
+			t.Errorf("%s: Unexpected progress check due in %v", tt.name, due.Sub(start))
+		}
+
+		var err error
+		for range tt.polls {
+			start = time.Now()
+			err = s.CheckProgress()
+			if due, ok := s.ProgressDue(); ok && (due.Before(start.Add(3*time.Second)) || due.After(time.Now().Add(3*time.Second))) {
+				t.Errorf("%s: Unexpected retry due in %v", tt.name, due.Sub(start))
+			}
+		}
+
+		if len(polls) != 0 {
+			t.Errorf("%s: Unexpected polls left: %d", tt.name, len(polls))
+		}
+		if _, ok := s.ProgressDue(); ok {
+			t.Errorf("%s: Unexpected progress check still due", tt.name)
+		}
+		if s.Active() != tt.healthy || s.health.Error != tt.expected {
+			t.Errorf("%s: Unexpected health. Expected: %v %q, Got: %v %q", tt.name, tt.healthy, tt.expected, s.Active(), s.health.Error)
+		}
+		if (err == nil) != tt.healthy {
+			t.Errorf("%s: Unexpected error: %v", tt.name, err)
+		}
+	}
+
+	// Progress replaced by a newer health check while polling is ignored
+	s := &BaseStreamSource{active: true, mux: &sync.RWMutex{}}
+	s.expectProgress("100 seg100.ts", 6*time.Second, func() (string, error) {
+		s.expectProgress("110 seg110.ts", 6*time.Second, nil)
+		return "", unreachable
+	})
+	if err := s.CheckProgress(); err != nil || !s.Active() {
+		t.Errorf("Unexpected failure of a replaced progress check: %v", err)
+	}
+	if s.progress == nil || s.progress.marker != "110 seg110.ts" || s.progress.retried {
+		t.Errorf("Unexpected progress: %+v", s.progress)
+	}
+}
@@ -0,0 +1,236 @@
+package types
+
+import (
+	"bytes"
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+	"golang.org/x/text/language"
+)
+
+const dashRoleScheme = "urn:mpeg:dash:role:2011"
+
+// languageRank returns the position of a language in the preferred ones, -1
+// if it isn't preferred. Languages match on their base language, pt matches
+// pt-BR and por.
+func languageRank(languages []string, lang string) int {
+	base, ok := languageBase(lang)
+	if !ok {
+		return -1
+	}
+	return slices.IndexFunc(languages, func(preferred string) bool {
+		b, ok := languageBase(preferred)
+		return ok && b == base
+	})
+}
+
+func languageBase(lang string) (language.Base, bool) {
+	tag, err := language.Parse(strings.TrimSpace(lang))
+	if err != nil {
+		return language.Base{}, false
+	}
+	base, confidence := tag.Base()
+	return base, confidence == language.Exact
+}
+
+// sortByLanguage sorts items by their rank, keeping the order of the ones
+// with the same rank and placing the ones not preferred last. Without a
+// preferred item, nil is returned.
+func sortByLanguage[T any](items []T, rank func(T) int) []T {
+	if !slices.ContainsFunc(items, func(item T) bool { return rank(item) >= 0 }) {
+		return nil
+	}
+
+	key := func(item T) int {
+		if r := rank(item); r >= 0 {
+			return r
+		}
+		return math.MaxInt
+	}
+	sorted := slices.Clone(items)
+	slices.SortStableFunc(sorted, func(a, b T) int { return cmp.Compare(key(a), key(b)) })
+	return sorted
+}
+
+type mediaRendition struct {
+	line  int
+	attrs m3uparser.HLSAttributes
+}
+
+// selectLanguages reorders the audio and subtitle renditions of each group of
+// a master playlist so the preferred languages come first, the best one
+// being the default. With only set, the renditions in other languages are
+// dropped. Groups without a preferred language are left unchanged, and so
+// are media playlists.
+func selectLanguages(body []byte, languages []string, only bool) []byte {
+	if len(languages) == 0 || !bytes.Contains(body, []byte("#EXT-X-MEDIA:")) {
+		return body
+	}
+
+	lines := readLines(body)
+	groups := make(map[string][]*mediaRendition)
+	var order []string
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		attrs := m3uparser.ParseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+		mediaType := attrs.GetValue("TYPE")
+		if mediaType != "AUDIO" && mediaType != "SUBTITLES" {
+			continue
+		}
+		key := mediaType + "/" + attrs.GetValue("GROUP-ID")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], &mediaRendition{line: i, attrs: attrs})
+	}
+
+	rank := func(rendition *mediaRendition) int {
+		language, ok := rendition.attrs.Get("LANGUAGE")
+		if !ok {
+			return -1
+		}
+		return languageRank(languages, language)
+	}
+
+	dropped := make(map[int]bool)
+	for _, key := range order {
+		renditions := groups[key]
+		sorted := sortByLanguage(renditions, rank)
+		if sorted == nil {
+			continue
+		}
+
+		// Subtitles are only shown by default if the provider did so
+		setDefault := strings.HasPrefix(key, "AUDIO/") || slices.ContainsFunc(renditions, func(rendition *mediaRendition) bool {
+			return rendition.attrs.GetValue("DEFAULT") == "YES"
+		})
+		if only {
+			// Renditions without a language are kept, they may be the only ones
+			// with the content
+			sorted = slices.DeleteFunc(sorted, func(rendition *mediaRendition) bool {
+				_, ok := rendition.attrs.Get("LANGUAGE")
+				return ok && rank(rendition) < 0
+			})
+		}
+
+		for k, rendition := range sorted {
+			if rank(rendition) >= 0 {
+				rendition.attrs.Set("AUTOSELECT", "YES")
+			}
+			if setDefault {
+				if k == 0 {
+					rendition.attrs.Set("DEFAULT", "YES")
+				} else {
+					rendition.attrs.Set("DEFAULT", "NO")
+				}
+			}
+			lines[renditions[k].line] = "#EXT-X-MEDIA:" + rendition.attrs.String()
+		}
+		for _, rendition := range renditions[len(sorted):] {
+			dropped[rendition.line] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if !dropped[i] {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// selectAdaptationSets reorders the audio and text adaptation sets of a
+// period so the preferred languages come first, the best audio one getting
+// the main role. With only set, the sets in other languages are dropped.
+func selectAdaptationSets(period *mpd.Period, languages []string, only bool) {
+	rank := func(adaptationSet *mpd.AdaptationSet) int {
+		if adaptationSet.Lang == nil {
+			return -1
+		}
+		return languageRank(languages, *adaptationSet.Lang)
+	}
+
+	dropped := make(map[int]bool)
+	for _, kind := range []string{"audio", "text"} {
+		var positions []int
+		var adaptationSets []*mpd.AdaptationSet
+		for i, adaptationSet := range period.AdaptationSets {
+			if len(adaptationSet.Representations) > 0 && mediaKind(adaptationSet, &adaptationSet.Representations[0]) == kind {
+				positions = append(positions, i)
+				adaptationSets = append(adaptationSets, adaptationSet)
+			}
+		}
+
+		sorted := sortByLanguage(adaptationSets, rank)
+		if sorted == nil {
+			continue
+		}
+
+		// Text is only shown by default if the provider did so
+		setRole := kind == "audio" || slices.ContainsFunc(adaptationSets, isMainRole)
+		if only {
+			sorted = slices.DeleteFunc(sorted, func(adaptationSet *mpd.AdaptationSet) bool {
+				return adaptationSet.Lang != nil && rank(adaptationSet) < 0
+			})
+		}
+
+		for k, adaptationSet := range sorted {
+			if setRole {
+				setMainRole(adaptationSet, k == 0)
+			}
+			period.AdaptationSets[positions[k]] = adaptationSet
+		}
+		for _, position := range positions[len(sorted):] {
+			dropped[position] = true
+		}
+	}
+
+	adaptationSets := make([]*mpd.AdaptationSet, 0, len(period.AdaptationSets))
+	for i, adaptationSet := range period.AdaptationSets {
+		if !dropped[i] {
+			adaptationSets = append(adaptationSets, adaptationSet)
+		}
+	}
+	period.AdaptationSets = adaptationSets
+}
+
+func isMainRole(adaptationSet *mpd.AdaptationSet) bool {
+	return slices.ContainsFunc(adaptationSet.Role, func(role *mpd.Descriptor) bool {
+		return valueOr(role.SchemeIDURI, "") == dashRoleScheme && valueOr(role.Value, "") == "main"
+	})
+}
+
+// setMainRole gives or takes the main role of an adaptation set, taken roles
+// become alternate.
+func setMainRole(adaptationSet *mpd.AdaptationSet, main bool) {
+	if isMainRole(adaptationSet) == main {
+		return
+	}
+	for _, role := range adaptationSet.Role {
+		if valueOr(role.SchemeIDURI, "") != dashRoleScheme {
+			continue
+		}
+		switch value := valueOr(role.Value, ""); {
+		case main && value == "alternate":
+			role.Value = ptr("main")
+			return
+		case !main && value == "main":
+			role.Value = ptr("alternate")
+		}
+	}
+	if main {
+		adaptationSet.Role = append(adaptationSet.Role, &mpd.Descriptor{SchemeIDURI: ptr(dashRoleScheme), Value: ptr("main")})
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,170 @@
+package types
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	mpd "github.com/a13labs/m3uproxy/pkg/mpdparser"
+)
+
+const languagesTestMaster = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,URI="en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="Português",LANGUAGE="pt-BR",DEFAULT=NO,AUTOSELECT=NO,URI="pt.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="Français",LANGUAGE="fr",DEFAULT=NO,URI="fr.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="Commentary",DEFAULT=NO,URI="commentary.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=NO,URI="en.vtt.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Português",LANGUAGE="por",DEFAULT=NO,URI="pt.vtt.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,AUDIO="aud",SUBTITLES="subs"
+video.m3u8
+`
+
+// mediaRenditions returns the NAME, DEFAULT and AUTOSELECT of the renditions
+// of a master playlist, in order.
+func mediaRenditions(body []byte) []string {
+	var renditions []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		attrs := m3uparser.ParseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+		renditions = append(renditions, attrs.GetValue("NAME")+" "+attrs.GetValue("DEFAULT")+" "+attrs.GetValue("AUTOSELECT"))
+	}
+	return renditions
+}
+
+func TestSelectLanguages(t *testing.T) {
+	unchanged := mediaRenditions([]byte(languagesTestMaster))
+
+	tests := []struct {
+		name      string
+		languages []string
+		only      bool
+		expected  []string
+	}{
+		{"no languages", nil, false, unchanged},
+		{"no preferred language", []string{"de"}, false, unchanged},
+		{
+			"preferred first", []string{"pt", "en"}, false,
+			[]string{"Português YES YES", "English NO YES", "Français NO ", "Commentary NO ", "Português NO YES", "English NO YES"},
+		},
+		{
+			"fallback to the next language", []string{"es", "en", "pt"}, false,
+			[]string{"English YES YES", "Português NO YES", "Français NO ", "Commentary NO ", "English NO YES", "Português NO YES"},
+		},
+		{
+			"three letter code", []string{"por"}, false,
+			[]string{"Português YES YES", "English NO YES", "Français NO ", "Commentary NO ", "Português NO YES", "English NO "},
+		},
+		{
+			"only preferred", []string{"pt"}, true,
+			[]string{"Português YES YES", "Commentary NO ", "Português NO YES"},
+		},
+		{
+			"only preferred in order", []string{"fr", "en"}, true,
+			[]string{"Français YES YES", "English NO YES", "Commentary NO ", "English NO YES"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := selectLanguages([]byte(languagesTestMaster), tt.languages, tt.only)
+		if renditions := mediaRenditions(got); !slices.Equal(renditions, tt.expected) {
+			t.Errorf("%s: Unexpected renditions. Expected: %q, Got: %q", tt.name, tt.expected, renditions)
+		}
+		if !strings.HasSuffix(string(got), "#EXT-X-STREAM-INF:BANDWIDTH=2500000,AUDIO=\"aud\",SUBTITLES=\"subs\"\nvideo.m3u8\n") {
+			t.Errorf("%s: Unexpected variants:\n%s", tt.name, got)
+		}
+	}
+
+	media := []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\nseg.ts\n")
+	if got := selectLanguages(media, []string{"pt"}, true); string(got) != string(media) {
+		t.Errorf("Unexpected change of a media playlist:\n%s", got)
+	}
+}
+
+const languagesTestMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT60S">
+  <Period id="1">
+    <AdaptationSet id="video" mimeType="video/mp4">
+      <Representation id="v1" bandwidth="2500000"/>
+    </AdaptationSet>
+    <AdaptationSet id="audio-en" mimeType="audio/mp4" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>
+      <Representation id="a1" bandwidth="128000"/>
+    </AdaptationSet>
+    <AdaptationSet id="audio-pt" mimeType="audio/mp4" lang="pt-BR">
+      <Representation id="a2" bandwidth="128000"/>
+    </AdaptationSet>
+    <AdaptationSet id="audio-fr" mimeType="audio/mp4" lang="fr">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="alternate"/>
+      <Representation id="a3" bandwidth="128000"/>
+    </AdaptationSet>
+    <AdaptationSet id="text-en" mimeType="text/vtt" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="subtitle"/>
+      <Representation id="t1" bandwidth="1000"/>
+    </AdaptationSet>
+    <AdaptationSet id="text-pt" mimeType="text/vtt" lang="pt">
+      <Representation id="t2" bandwidth="1000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+// adaptationSetRoles returns the id and DASH roles of the adaptation sets of a
+// period, in order.
+func adaptationSetRoles(period *mpd.Period) []string {
+	var result []string
+	for _, adaptationSet := range period.AdaptationSets {
+		var roles []string
+		for _, role := range adaptationSet.Role {
+			if valueOr(role.SchemeIDURI, "") == dashRoleScheme {
+				roles = append(roles, valueOr(role.Value, ""))
+			}
+		}
+		result = append(result, valueOr(adaptationSet.ID, "")+":"+strings.Join(roles, ","))
+	}
+	return result
+}
+
+func TestSelectAdaptationSets(t *testing.T) {
+	mainText := strings.Replace(languagesTestMPD, `value="subtitle"`, `value="main"`, 1)
+	unchanged := []string{"video:", "audio-en:main", "audio-pt:", "audio-fr:alternate", "text-en:subtitle", "text-pt:"}
+
+	tests := []struct {
+		name      string
+		manifest  string
+		languages []string
+		only      bool
+		expected  []string
+	}{
+		{"no preferred language", languagesTestMPD, []string{"de"}, false, unchanged},
+		{
+			"preferred first", languagesTestMPD, []string{"pt", "en"}, false,
+			[]string{"video:", "audio-pt:main", "audio-en:alternate", "audio-fr:alternate", "text-pt:", "text-en:subtitle"},
+		},
+		{
+			"alternate becomes main", languagesTestMPD, []string{"es", "fr"}, false,
+			[]string{"video:", "audio-fr:main", "audio-en:alternate", "audio-pt:", "text-en:subtitle", "text-pt:"},
+		},
+		{
+			"main text follows the language", mainText, []string{"pt"}, false,
+			[]string{"video:", "audio-pt:main", "audio-en:alternate", "audio-fr:alternate", "text-pt:main", "text-en:alternate"},
+		},
+		{
+			"only preferred", languagesTestMPD, []string{"pt"}, true,
+			[]string{"video:", "audio-pt:main", "text-pt:"},
+		},
+		{
+			"only preferred in order", languagesTestMPD, []string{"en", "pt"}, true,
+			[]string{"video:", "audio-en:main", "audio-pt:", "text-en:subtitle", "text-pt:"},
+		},
+	}
+
+	for _, tt := range tests {
+		period := decodeTestMPD(t, tt.manifest).Period[0]
+		selectAdaptationSets(period, tt.languages, tt.only)
+		if got := adaptationSetRoles(period); !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: Unexpected adaptation sets. Expected: %q, Got: %q", tt.name, tt.expected, got)
+		}
+	}
+}
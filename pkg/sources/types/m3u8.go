@@ -3,17 +3,33 @@ package types
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/cache"
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+	"github.com/elnormous/contenttype"
 )
 
 type M3U8StreamSource struct {
 	BaseStreamSource
+	manifests  *cache.ManifestCache
+	sequencer  *MediaSequencer
+	segmentTTL atomic.Int64
+	cacheStats cacheCounters
+}
+
+// SetMediaSequencer shares the channel's media sequencer with the source, so
+// media playlists stay continuous when switching sources.
+func (s *M3U8StreamSource) SetMediaSequencer(sequencer *MediaSequencer) {
+	s.sequencer = sequencer
 }
 
 func (s *M3U8StreamSource) parseUrl(r *http.Request) (*url.URL, error) {
@@ -22,127 +38,376 @@ func (s *M3U8StreamSource) parseUrl(r *http.Request) (*url.URL, error) {
 		return url.Parse(s.m3u.URI)
 	}
 
-	originalUrlBytes, err := base64.URLEncoding.DecodeString(orig)
+	originalUrl, err := signer.verify(requestChannel(r), orig)
 	if err != nil {
 		return nil, err
 	}
 
-	return url.Parse(string(originalUrlBytes))
+	return url.Parse(originalUrl)
+}
+
+// rewriteAttribute replaces the value of an attribute of a tag line, keeping
+// its quotes. Lines without the attribute are returned unchanged.
+func rewriteAttribute(line string, name string, rewrite func(string) (string, error)) (string, error) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return line, nil
+	}
+
+	inQuotes := false
+	start := colon + 1
+	for i := start; i <= len(line); i++ {
+		if i < len(line) && line[i] == '"' {
+			inQuotes = !inQuotes
+			continue
+		}
+		if i < len(line) && (line[i] != ',' || inQuotes) {
+			continue
+		}
+
+		attr := strings.TrimLeft(line[start:i], " ")
+		attrStart := i - len(attr)
+		if strings.HasPrefix(attr, name+"=") {
+			value := attr[len(name)+1:]
+			quoted := len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")
+			if quoted {
+				value = value[1 : len(value)-1]
+			}
+			value, err := rewrite(value)
+			if err != nil {
+				return "", err
+			}
+			if quoted {
+				value = "\"" + value + "\""
+			}
+			return line[:attrStart] + name + "=" + value + line[i:], nil
+		}
+		start = i + 1
+	}
+	return line, nil
+}
+
+// rewriteURIAttribute replaces the value of the URI attribute of a tag line.
+func rewriteURIAttribute(line string, rewrite func(string) (string, error)) (string, error) {
+	return rewriteAttribute(line, "URI", rewrite)
+}
+
+// proxyURI resolves ref against the playlist URI and returns the signed URL
+// of the given proxy endpoint serving it. Non HTTP URIs (skd://, data:) are
+// left untouched.
+func proxyURI(ref string, uri *url.URL, channel string, endpoint string) (string, error) {
+	target, err := resolveReference(uri.String(), ref)
+	if err != nil {
+		return "", err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return ref, nil
+	}
+	return fmt.Sprintf("%s?o=%s", endpoint, signer.sign(channel, target.String())), nil
+}
+
+func tagName(line string) string {
+	name := strings.TrimPrefix(line, "#")
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
 }
 
-func (s *M3U8StreamSource) remap(body []byte, w http.ResponseWriter, uri *url.URL) {
+func (s *M3U8StreamSource) remap(body []byte, w io.Writer, uri *url.URL, channel string, filter RenditionFilter) error {
+	body = selectLanguages(filterMasterPlaylist(body, filter), filter.Languages, filter.OnlyLanguages)
 	buf := bufio.NewReader(bytes.NewReader(body))
 
 	// Validate header
 	line, err := buf.ReadString('\n')
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if err != nil && err != io.EOF {
+		return err
 	}
 
 	if !strings.HasPrefix(line, "#EXTM3U") {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return errors.New("invalid playlist header")
 	}
 
-	w.Write([]byte(line))
-	var in_entry = false
-	var playlistType = "master"
+	w.Write([]byte(strings.TrimRight(line, "\r\n") + "\n"))
 
+	var window mediaWindow
+	var seqOffset int64
+	sequenced := false
+	insertDiscontinuity := false
+	hasDiscontinuitySequence := bytes.Contains(body, []byte("#EXT-X-DISCONTINUITY-SEQUENCE:"))
+	if upstream, ok := scanMediaWindow(body); ok {
+		s.segmentTTL.Store(int64(upstream.segmentTTL()))
+		if s.sequencer != nil {
+			window = s.sequencer.Map(s.Url(), playlistKey(uri), upstream)
+			seqOffset = window.mediaSequence - upstream.mediaSequence
+			sequenced = true
+			insertDiscontinuity = window.leadingDiscontinuity && !upstream.leadingDiscontinuity
+		}
+	}
+
+	mediaEndpoint := func(name string) func(string) (string, error) {
+		return func(ref string) (string, error) {
+			return proxyURI(ref, uri, channel, "media/"+name)
+		}
+	}
+	manifestEndpoint := func(ref string) (string, error) {
+		return proxyURI(ref, uri, channel, "master.m3u8")
+	}
+
+	entryTag := ""
 	for {
 		line, err := buf.ReadString('\n')
-		if err == io.EOF {
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" && err == io.EOF {
 			break
 		}
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-
-		if line != "" && !strings.HasPrefix(line, "#") {
-			if in_entry {
-				line = strings.TrimRight(line, "\r\n")
-				if !strings.HasPrefix(line, "http") {
-					p := path.Dir(uri.EscapedPath())
-					line = uri.Scheme + "://" + path.Join(uri.Host, path.Join(p, line))
-				}
-
-				remap := base64.URLEncoding.EncodeToString([]byte(line))
-
-				switch playlistType {
-				case "master":
-					w.Write([]byte(fmt.Sprintf("master.m3u8?o=%s\n", remap)))
-				case "media":
-					w.Write([]byte(fmt.Sprintf("media/media.ts?o=%s\n", remap)))
-				default:
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-
-				in_entry = false
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			w.Write([]byte("\n"))
 			continue
 		}
-		w.Write([]byte(line))
 
-		line = strings.TrimPrefix(line, "#")
-		if strings.HasPrefix(line, "EXTINF") || strings.HasPrefix(line, "EXT-X-STREAM-INF") {
-			in_entry = true
+		if !strings.HasPrefix(line, "#") {
+			var remapped string
+			var rerr error
+			switch entryTag {
+			case "EXT-X-STREAM-INF":
+				remapped, rerr = manifestEndpoint(line)
+			case "EXTINF":
+				remapped, rerr = mediaEndpoint("media.ts")(line)
+			default:
+				return errors.New("unexpected URI line")
+			}
+			if rerr != nil {
+				return rerr
+			}
+			w.Write([]byte(remapped + "\n"))
+			entryTag = ""
 			continue
 		}
 
-		if strings.HasPrefix(line, "EXT-X-INDEPENDENT-SEGMENTS") {
-			playlistType = "master"
-		} else if strings.HasPrefix(line, "EXT-X-MEDIA-SEQUENCE") {
-			playlistType = "media"
+		tag := tagName(line)
+		switch tag {
+		case "EXT-X-KEY", "EXT-X-SESSION-KEY":
+			line, err = rewriteURIAttribute(line, mediaEndpoint("key"))
+		case "EXT-X-MAP":
+			line, err = rewriteURIAttribute(line, mediaEndpoint("init.mp4"))
+		case "EXT-X-PART", "EXT-X-PRELOAD-HINT":
+			line, err = rewriteURIAttribute(line, mediaEndpoint("media.ts"))
+		case "EXT-X-MEDIA", "EXT-X-I-FRAME-STREAM-INF":
+			line, err = rewriteURIAttribute(line, manifestEndpoint)
+		case "EXT-X-RENDITION-REPORT":
+			line, err = rewriteURIAttribute(line, manifestEndpoint)
+			if err == nil && sequenced {
+				line, err = rewriteAttribute(line, "LAST-MSN", func(value string) (string, error) {
+					msn, err := strconv.ParseInt(value, 10, 64)
+					if err != nil {
+						return "", err
+					}
+					return strconv.FormatInt(msn+seqOffset, 10), nil
+				})
+			}
+		case "EXTINF", "EXT-X-STREAM-INF":
+			entryTag = tag
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case sequenced && tag == "EXT-X-MEDIA-SEQUENCE":
+			w.Write([]byte(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", window.mediaSequence)))
+			if !hasDiscontinuitySequence && window.discontinuitySequence != 0 {
+				w.Write([]byte(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", window.discontinuitySequence)))
+			}
+		case sequenced && tag == "EXT-X-DISCONTINUITY-SEQUENCE":
+			w.Write([]byte(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", window.discontinuitySequence)))
+		case insertDiscontinuity && (tag == "EXTINF" || tag == "EXT-X-PART"):
+			w.Write([]byte("#EXT-X-DISCONTINUITY\n"))
+			w.Write([]byte(line + "\n"))
+			insertDiscontinuity = false
+		default:
+			w.Write([]byte(line + "\n"))
 		}
 	}
+	return nil
+}
+
+// withDeliveryDirectives forwards the LL-HLS blocking reload and delta update
+// query parameters of the request to the origin, media sequence numbers are
+// translated back to the origin's numbering.
+func (s *M3U8StreamSource) withDeliveryDirectives(r *http.Request, uri *url.URL) *url.URL {
+	query := r.URL.Query()
+	directives := url.Values{}
+
+	if msn, err := strconv.ParseInt(query.Get("_HLS_msn"), 10, 64); err == nil {
+		ok := true
+		if s.sequencer != nil {
+			msn, ok = s.sequencer.Unmap(playlistKey(uri), msn)
+		}
+		if ok && msn >= 0 {
+			directives.Set("_HLS_msn", strconv.FormatInt(msn, 10))
+			if part := query.Get("_HLS_part"); part != "" {
+				directives.Set("_HLS_part", part)
+			}
+		}
+	}
+	if skip := query.Get("_HLS_skip"); skip != "" {
+		directives.Set("_HLS_skip", skip)
+	}
+
+	if len(directives) == 0 {
+		return uri
+	}
+
+	target := *uri
+	if target.RawQuery != "" {
+		target.RawQuery += "&"
+	}
+	target.RawQuery += directives.Encode()
+	return &target
 }
 
 func (s *M3U8StreamSource) MasterPlaylist() string {
 	return "master.m3u8"
 }
 
-func (s *M3U8StreamSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) {
-
-	uri, err := s.parseUrl(r)
+// failoverManifest fetches the source's own playlist for a request that was
+// failed over from another source. When the client was following a variant
+// and our playlist is a master, the first variant is served instead.
+func (s *M3U8StreamSource) failoverManifest(r *http.Request) ([]byte, contenttype.MediaType, *url.URL, error) {
+	uri, err := url.Parse(s.m3u.URI)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return nil, contenttype.MediaType{}, nil, err
 	}
 
 	body, _, ct, err := s.conn.Get("GET", uri.String())
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return nil, ct, nil, err
+	}
+
+	if r.URL.Query().Get("o") == "" || !bytes.Contains(body, []byte("#EXT-X-STREAM-INF")) {
+		return body, ct, uri, nil
+	}
+
+	master, err := m3uparser.DecodeMasterPlaylist(bytes.NewReader(body))
+	if err != nil {
+		return nil, ct, nil, err
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	if s.disableRemap {
-		w.Write(body)
-		return
+	var variant *m3uparser.Variant
+	for _, v := range master.Variants {
+		if !v.IFrame {
+			variant = v
+			break
+		}
+	}
+	if variant == nil {
+		return nil, ct, nil, errors.New("empty playlist")
+	}
+
+	uri, err = resolveReference(uri.String(), variant.URI)
+	if err != nil {
+		return nil, ct, nil, err
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	s.remap(body, w, uri)
+	body, _, ct, err = s.conn.Get("GET", uri.String())
+	if err != nil {
+		return nil, ct, nil, err
+	}
+	return body, ct, uri, nil
 }
 
-func (s *M3U8StreamSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) {
+// manifestTTL returns for how long a playlist can be served from cache, a
+// fraction of the target duration for media playlists, of the part target
+// for low latency ones. Responses to blocking reloads are never cached.
+func (s *M3U8StreamSource) manifestTTL(body []byte, uri *url.URL) time.Duration {
+	if uri.Query().Has("_HLS_msn") {
+		return 0
+	}
+	window, ok := scanMediaWindow(body)
+	if !ok || window.targetDuration <= 0 {
+		return defaultManifestTTL
+	}
+	if window.partTarget > 0 {
+		return time.Duration(window.partTarget * float64(time.Second) / 2)
+	}
+	return time.Duration(window.targetDuration * float64(time.Second) / 2)
+}
+
+func (s *M3U8StreamSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) error {
 
 	uri, err := s.parseUrl(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	body, _, ct, err := s.conn.Get("GET", uri.String())
+	if !isFailover(r) {
+		uri = s.withDeliveryDirectives(r, uri)
+	}
+
+	filter := s.renditionFilter(r)
+	key := uri.String() + filter.cacheKey()
+	if isFailover(r) {
+		key = "failover:" + key
+	}
+
+	manifest, err := s.manifests.Get(key, func() (cache.Manifest, time.Duration, error) {
+		var body []byte
+		var ct contenttype.MediaType
+		var err error
+		target := uri
+		if isFailover(r) {
+			body, ct, target, err = s.failoverManifest(r)
+		} else {
+			body, _, ct, err = s.conn.Get("GET", target.String())
+		}
+		if err != nil {
+			return cache.Manifest{}, 0, fmt.Errorf("%w: %v", ErrUpstream, err)
+		}
+
+		if s.disableRemap {
+			return cache.Manifest{Data: body, MediaType: ct.String()}, s.manifestTTL(body, target), nil
+		}
+
+		remapped := new(bytes.Buffer)
+		if err := s.remap(body, remapped, target, requestChannel(r), filter); err != nil {
+			return cache.Manifest{}, 0, err
+		}
+		return cache.Manifest{Data: remapped.Bytes(), MediaType: ct.String()}, s.manifestTTL(body, target), nil
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+		return err
 	}
 
-	w.Header().Set("Content-Type", ct.String())
-	w.Write(body)
+	w.Header().Set("Content-Type", manifest.MediaType)
+	w.Write(manifest.Data)
+	return nil
+}
+
+func (s *M3U8StreamSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) error {
+
+	uri, err := s.parseUrl(r)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	if segmentCache == nil {
+		return s.relayMedia(w, r, uri)
+	}
+
+	ttl := time.Duration(s.segmentTTL.Load())
+	if ttl == 0 {
+		ttl = defaultSegmentTTL
+	}
+	return s.serveCachedMedia(w, r, uri, ttl, &s.cacheStats)
+}
+
+func (s *M3U8StreamSource) Diagnostic() StreamSourceDiag {
+	diag := s.BaseStreamSource.Diagnostic()
+	diag.Cache = s.cacheStats.diagnostic()
+	return diag
 }
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestManifestTTL(t *testing.T) {
+	media := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:100\n#EXTINF:6.0,\nseg100.ts\n#EXTINF:6.0,\nseg101.ts\n"
+	lowLatency := "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.0\n#EXT-X-PART-INF:PART-TARGET=1.0\n" +
+		"#EXT-X-MEDIA-SEQUENCE:100\n#EXTINF:4.0,\nseg100.mp4\n#EXT-X-PART:DURATION=1.0,URI=\"seg101.0.mp4\"\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg101.1.mp4\"\n"
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nlow.m3u8\n"
+
+	tests := []struct {
+		name     string
+		body     string
+		uri      string
+		expected time.Duration
+	}{
+		{"media playlist", media, "https://example.com/index.m3u8", 3 * time.Second},
+		{"low latency", lowLatency, "https://example.com/index.m3u8", 500 * time.Millisecond},
+		{"delta update", lowLatency, "https://example.com/index.m3u8?_HLS_skip=YES", 500 * time.Millisecond},
+		{"blocking reload", lowLatency, "https://example.com/index.m3u8?_HLS_msn=101&_HLS_part=1", 0},
+		{"blocking reload without parts", media, "https://example.com/index.m3u8?_HLS_msn=102", 0},
+		{"master playlist", master, "https://example.com/master.m3u8", defaultManifestTTL},
+	}
+
+	s := &M3U8StreamSource{}
+	for _, tt := range tests {
+		uri, _ := url.Parse(tt.uri)
+		if got := s.manifestTTL([]byte(tt.body), uri); got != tt.expected {
+			t.Errorf("%s: Unexpected TTL. Expected: %s, Got: %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestWithDeliveryDirectives(t *testing.T) {
+	origin, _ := url.Parse("https://example.com/live/index.m3u8?token=abc")
+	sequencer := NewMediaSequencer()
+	// After the switch the proxy numbering is 905 ahead of the origin's
+	sequencer.Map("other", playlistKey(origin), mediaWindow{mediaSequence: 1000, segments: 5})
+	sequencer.Map("source", playlistKey(origin), mediaWindow{mediaSequence: 100, segments: 5})
+
+	tests := []struct {
+		name      string
+		query     string
+		sequencer *MediaSequencer
+		expected  string
+	}{
+		{"no directives", "", nil, "https://example.com/live/index.m3u8?token=abc"},
+		{"blocking reload", "?_HLS_msn=105&_HLS_part=2", nil, "https://example.com/live/index.m3u8?token=abc&_HLS_msn=105&_HLS_part=2"},
+		{"delta update", "?_HLS_skip=v2", nil, "https://example.com/live/index.m3u8?token=abc&_HLS_skip=v2"},
+		{"part without msn", "?_HLS_part=2", nil, "https://example.com/live/index.m3u8?token=abc"},
+		{"mapped sequence", "?_HLS_msn=1006&_HLS_part=0", sequencer, "https://example.com/live/index.m3u8?token=abc&_HLS_msn=101&_HLS_part=0"},
+		{"sequence before the origin's", "?_HLS_msn=900&_HLS_skip=YES", sequencer, "https://example.com/live/index.m3u8?token=abc&_HLS_skip=YES"},
+	}
+
+	for _, tt := range tests {
+		s := &M3U8StreamSource{sequencer: tt.sequencer}
+		r := httptest.NewRequest("GET", "/token/channel1/index.m3u8"+tt.query, nil)
+		if got := s.withDeliveryDirectives(r, origin).String(); got != tt.expected {
+			t.Errorf("%s: Unexpected URL. Expected: %s, Got: %s", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/a13labs/m3uproxy/pkg/cache"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
 	"github.com/a13labs/m3uproxy/pkg/upstream"
 	"github.com/elnormous/contenttype"
@@ -29,6 +30,29 @@ var supportedMediaTypes = []contenttype.MediaType{
 	contenttype.NewMediaType("application/dash+xml"),
 }
 
+// Media types of manifests, anything else is media.
+var manifestMediaTypes = []contenttype.MediaType{
+	contenttype.NewMediaType("application/vnd.apple.mpegurl"),
+	contenttype.NewMediaType("application/x-mpegurl"),
+	contenttype.NewMediaType("audio/x-mpegurl"),
+	contenttype.NewMediaType("application/dash+xml"),
+}
+
+// Media types served as a single endless HTTP body, shared through a relay.
+var continuousMediaTypes = []contenttype.MediaType{
+	contenttype.NewMediaType("audio/mpeg"),
+	contenttype.NewMediaType("audio/aacp"),
+	contenttype.NewMediaType("audio/aac"),
+	contenttype.NewMediaType("audio/mp3"),
+	contenttype.NewMediaType("audio/ac3"),
+	contenttype.NewMediaType("audio/x-aac"),
+	contenttype.NewMediaType("video/mp2t"),
+	contenttype.NewMediaType("video/m2ts"),
+}
+
+// Manifests larger than this are rejected.
+const maxManifestSize = 16 * 1024 * 1024
+
 func NewSource(entry m3uparser.M3UEntry, timeout int) (StreamSource, error) {
 	radio := entry.ExtInfTags.GetValue("radio")
 
@@ -86,6 +110,11 @@ func NewSource(entry m3uparser.M3UEntry, timeout int) (StreamSource, error) {
 		}
 	}
 
+	var filter RenditionFilter
+	for _, tag := range entry.SearchTags("M3UPROXYFILTER") {
+		filter.parseOption(tag.Value)
+	}
+
 	// Clear non-standard tags
 	entry.ClearTags()
 
@@ -104,32 +133,30 @@ func NewSource(entry m3uparser.M3UEntry, timeout int) (StreamSource, error) {
 		return nil, fmt.Errorf("invalid content type: %s", ct)
 	}
 
+	base := BaseStreamSource{
+		m3u:              entry,
+		headers:          headers,
+		httpProxy:        proxy,
+		forceKodiHeaders: forceKodiHeaders,
+		radio:            radio != "",
+		conn:             conn,
+		disableRemap:     disableRemap,
+		filter:           filter,
+		mux:              &sync.RWMutex{},
+	}
+
 	switch {
 	case ct.Subtype == "dash+xml":
 		return &MPDStreamSource{
-			BaseStreamSource: BaseStreamSource{
-				m3u:              entry,
-				headers:          headers,
-				httpProxy:        proxy,
-				forceKodiHeaders: forceKodiHeaders,
-				radio:            radio != "",
-				conn:             conn,
-				disableRemap:     disableRemap,
-				mux:              &sync.RWMutex{},
-			},
+			BaseStreamSource: base,
+			manifests:        cache.NewManifestCache(),
 		}, nil
+	case ct.MatchesAny(continuousMediaTypes...):
+		return newContinuousStreamSource(base), nil
 	default:
 		return &M3U8StreamSource{
-			BaseStreamSource: BaseStreamSource{
-				m3u:              entry,
-				headers:          headers,
-				httpProxy:        proxy,
-				forceKodiHeaders: forceKodiHeaders,
-				radio:            radio != "",
-				conn:             conn,
-				disableRemap:     disableRemap,
-				mux:              &sync.RWMutex{},
-			},
+			BaseStreamSource: base,
+			manifests:        cache.NewManifestCache(),
 		}, nil
 	}
 }
@@ -0,0 +1,226 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a13labs/m3uproxy/pkg/m3uparser"
+)
+
+// MediaSequencer keeps the media and discontinuity sequence numbers of a
+// channel's HLS media playlists continuous when the upstream changes, either
+// because the active source switched or because the upstream encoder restarted.
+// Each media playlist of the channel, variant or rendition, has its own
+// mapping as their upstream sequences may differ.
+type MediaSequencer struct {
+	mux       sync.Mutex
+	source    string
+	playlists map[string]*playlistSequence
+	nextSeq   int64 // proxy media sequence following the last served segment
+	lastDisc  int64 // proxy discontinuity sequence of the last served segment
+}
+
+// playlistSequence is the mapping of one media playlist.
+type playlistSequence struct {
+	lastSeq    int64 // upstream media sequence of the last served playlist
+	seqOffset  int64 // added to the upstream media sequence
+	discOffset int64 // added to the upstream discontinuity sequence
+	boundary   int64 // proxy media sequence where the current upstream started, -1 if none
+	nextSeq    int64 // proxy media sequence following the last served segment
+	lastDisc   int64 // proxy discontinuity sequence of the last served segment
+	mapped     time.Time
+}
+
+// Mappings of media playlists not served for this long are dropped.
+const playlistSequenceTTL = 5 * time.Minute
+
+// mediaWindow holds the sequence numbers of a media playlist.
+type mediaWindow struct {
+	mediaSequence         int64
+	discontinuitySequence int64
+	segments              int
+	discontinuities       int
+	leadingDiscontinuity  bool
+	targetDuration        float64
+	partTarget            float64 // low latency playlists only
+}
+
+func NewMediaSequencer() *MediaSequencer {
+	return &MediaSequencer{
+		playlists: make(map[string]*playlistSequence),
+	}
+}
+
+// playlistKey identifies a media playlist by its upstream URL, without the
+// LL-HLS delivery directives.
+func playlistKey(uri *url.URL) string {
+	key := *uri
+	query := key.Query()
+	for name := range query {
+		if strings.HasPrefix(name, "_HLS_") {
+			query.Del(name)
+		}
+	}
+	key.RawQuery = query.Encode()
+	return key.String()
+}
+
+// Map translates the upstream window of a media playlist of the given source
+// into the proxy's sequence space. When the upstream changed, the returned
+// window starts right after the last served segment and leadingDiscontinuity
+// is set, so a EXT-X-DISCONTINUITY must be inserted before the first segment.
+func (m *MediaSequencer) Map(source string, playlist string, upstream mediaWindow) mediaWindow {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	seq := upstream.mediaSequence
+	disc := upstream.discontinuitySequence
+	segments := int64(upstream.segments)
+	now := time.Now()
+
+	switched := m.source != "" && source != m.source
+	if switched {
+		clear(m.playlists)
+	}
+	m.source = source
+
+	p, ok := m.playlists[playlist]
+	if !ok {
+		p = &playlistSequence{boundary: -1}
+		if switched {
+			p.seqOffset = m.nextSeq - seq
+			p.discOffset = m.lastDisc - disc
+			p.boundary = m.nextSeq
+		} else if last := m.lastMapped(); last != nil {
+			// Renditions of the same upstream share its restarts
+			p.seqOffset = last.seqOffset
+			p.discOffset = last.discOffset
+			p.boundary = last.boundary
+		}
+		for key, other := range m.playlists {
+			if now.Sub(other.mapped) > playlistSequenceTTL {
+				delete(m.playlists, key)
+			}
+		}
+		m.playlists[playlist] = p
+	} else if seq+segments <= p.lastSeq {
+		p.seqOffset = p.nextSeq - seq
+		p.discOffset = p.lastDisc - disc
+		p.boundary = p.nextSeq
+	}
+	p.lastSeq = seq
+	p.mapped = now
+
+	result := mediaWindow{
+		mediaSequence:         seq + p.seqOffset,
+		discontinuitySequence: disc + p.discOffset,
+		segments:              upstream.segments,
+		discontinuities:       upstream.discontinuities,
+		leadingDiscontinuity:  upstream.leadingDiscontinuity,
+		targetDuration:        upstream.targetDuration,
+	}
+
+	if p.boundary >= 0 {
+		if result.mediaSequence <= p.boundary {
+			if !result.leadingDiscontinuity {
+				result.leadingDiscontinuity = true
+				result.discontinuities++
+			}
+		} else {
+			// The segment following the discontinuity left the window
+			result.discontinuitySequence++
+		}
+	}
+
+	if next := result.mediaSequence + segments; next > p.nextSeq {
+		p.nextSeq = next
+	}
+	if last := result.discontinuitySequence + int64(result.discontinuities); last > p.lastDisc {
+		p.lastDisc = last
+	}
+	m.nextSeq = max(m.nextSeq, p.nextSeq)
+	m.lastDisc = max(m.lastDisc, p.lastDisc)
+
+	return result
+}
+
+// lastMapped returns the mapping of the media playlist served last, nil if
+// none.
+func (m *MediaSequencer) lastMapped() *playlistSequence {
+	var last *playlistSequence
+	for _, p := range m.playlists {
+		if last == nil || p.mapped.After(last.mapped) {
+			last = p
+		}
+	}
+	return last
+}
+
+// Unmap translates a proxy media sequence number of a media playlist back to
+// the numbering of the upstream, returns false if the playlist isn't mapped.
+func (m *MediaSequencer) Unmap(playlist string, mediaSequence int64) (int64, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	p, ok := m.playlists[playlist]
+	if !ok {
+		return 0, false
+	}
+	return mediaSequence - p.seqOffset, true
+}
+
+// scanMediaWindow reads the sequence numbers of a media playlist, returns
+// false if body is not a media playlist.
+func scanMediaWindow(body []byte) (mediaWindow, bool) {
+	window := mediaWindow{}
+	isMedia := false
+	pendingDiscontinuity := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			isMedia = true
+			window.mediaSequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			window.targetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-PART-INF:"):
+			window.partTarget, _ = strconv.ParseFloat(m3uparser.ParseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-PART-INF:")).GetValue("PART-TARGET"), 64)
+		case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			window.discontinuitySequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-SKIP:"):
+			// Segments skipped by a playlist delta update are still in the window
+			skipped, _ := strconv.Atoi(m3uparser.ParseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-SKIP:")).GetValue("SKIPPED-SEGMENTS"))
+			window.segments += skipped
+		case line == "#EXT-X-DISCONTINUITY":
+			window.discontinuities++
+			pendingDiscontinuity = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if window.segments == 0 {
+				window.leadingDiscontinuity = pendingDiscontinuity
+			}
+			window.segments++
+		}
+	}
+
+	return window, isMedia && window.segments > 0
+}
+
+// segmentTTL is how long the window's segments stay available upstream.
+func (w mediaWindow) segmentTTL() time.Duration {
+	if w.targetDuration <= 0 {
+		return defaultSegmentTTL
+	}
+	segments := w.segments
+	if segments < 1 {
+		segments = 1
+	}
+	return time.Duration(w.targetDuration*float64(segments)) * time.Second
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMediaSequencerMap(t *testing.T) {
+	sequencer := NewMediaSequencer()
+
+	tests := []struct {
+		name          string
+		source        string
+		playlist      string
+		upstream      mediaWindow
+		mediaSequence int64
+		discSequence  int64
+		discontinuity bool
+	}{
+		{"first playlist", "a", "video", mediaWindow{mediaSequence: 100, segments: 5}, 100, 0, false},
+		{"playlist advancing", "a", "video", mediaWindow{mediaSequence: 101, segments: 5}, 101, 0, false},
+		{"other rendition", "a", "audio", mediaWindow{mediaSequence: 101, segments: 5}, 101, 0, false},
+		// The next source continues after the last served segment
+		{"source switch", "b", "video-b", mediaWindow{mediaSequence: 5000, discontinuitySequence: 3, segments: 4}, 106, 0, true},
+		{"switched source advancing", "b", "video-b", mediaWindow{mediaSequence: 5001, discontinuitySequence: 3, segments: 4}, 107, 1, false},
+		{"switched source rendition", "b", "audio-b", mediaWindow{mediaSequence: 5001, discontinuitySequence: 3, segments: 4}, 107, 1, false},
+		{"upstream restart", "b", "video-b", mediaWindow{mediaSequence: 1, discontinuitySequence: 3, segments: 4}, 111, 1, true},
+		{"switch back", "a", "video", mediaWindow{mediaSequence: 110, segments: 5}, 115, 2, true},
+		{"switched back advancing", "a", "video", mediaWindow{mediaSequence: 111, segments: 5}, 116, 3, false},
+	}
+
+	for _, tt := range tests {
+		got := sequencer.Map(tt.source, tt.playlist, tt.upstream)
+		if got.mediaSequence != tt.mediaSequence || got.discontinuitySequence != tt.discSequence || got.leadingDiscontinuity != tt.discontinuity {
+			t.Errorf("%s: Unexpected window. Expected: %d %d %v, Got: %d %d %v", tt.name, tt.mediaSequence, tt.discSequence, tt.discontinuity,
+				got.mediaSequence, got.discontinuitySequence, got.leadingDiscontinuity)
+		}
+		if got.segments != tt.upstream.segments {
+			t.Errorf("%s: Unexpected segments. Expected: %d, Got: %d", tt.name, tt.upstream.segments, got.segments)
+		}
+	}
+
+	// Blocking requests name the upstream media sequence
+	if msn, ok := sequencer.Unmap("video", 118); !ok || msn != 113 {
+		t.Errorf("Unexpected upstream media sequence. Expected: 113, Got: %d %v", msn, ok)
+	}
+	// The playlists of the previous source were dropped on the switch
+	if _, ok := sequencer.Unmap("video-b", 111); ok {
+		t.Errorf("Unexpected mapping of a playlist of the previous source")
+	}
+}
+
+func TestPlaylistKey(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"https://example.com/live/index.m3u8", "https://example.com/live/index.m3u8"},
+		{"https://example.com/live/index.m3u8?_HLS_msn=10&_HLS_part=2&token=abc", "https://example.com/live/index.m3u8?token=abc"},
+		{"https://example.com/live/index.m3u8?_HLS_skip=YES", "https://example.com/live/index.m3u8"},
+	}
+
+	for _, tt := range tests {
+		uri, _ := url.Parse(tt.uri)
+		if got := playlistKey(uri); got != tt.expected {
+			t.Errorf("Unexpected key of %s. Expected: %s, Got: %s", tt.uri, tt.expected, got)
+		}
+	}
+}
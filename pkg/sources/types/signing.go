@@ -0,0 +1,100 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid url signature")
+	ErrExpiredUrl       = errors.New("url expired")
+)
+
+// urlSigner signs the origin URLs embedded in remapped manifests, so the
+// proxy only fetches URLs it handed out for the same channel.
+type urlSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+var signer = newURLSigner(nil, 0)
+
+func newURLSigner(key []byte, ttl time.Duration) *urlSigner {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &urlSigner{
+		key: key,
+		ttl: ttl,
+	}
+}
+
+// SetURLSigning sets the key used to sign remapped URLs and for how long they
+// remain valid, a 0 ttl never expires. An empty key uses a random one, making
+// URLs invalid after a restart.
+func SetURLSigning(key string, ttl time.Duration) {
+	signer = newURLSigner([]byte(key), ttl)
+}
+
+func (s *urlSigner) mac(channel, encoded, expires string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(channel))
+	h.Write([]byte{0})
+	h.Write([]byte(encoded))
+	h.Write([]byte{0})
+	h.Write([]byte(expires))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// sign returns a token carrying the target URL, bound to the channel.
+func (s *urlSigner) sign(channel, target string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(target))
+	expires := "0"
+	if s.ttl > 0 {
+		expires = strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 36)
+	}
+	return encoded + "." + expires + "." + s.mac(channel, encoded, expires)
+}
+
+// verify checks the token was signed for the channel and didn't expire, and
+// returns the target URL.
+func (s *urlSigner) verify(channel, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(s.mac(channel, parts[0], parts[1]))) {
+		return "", ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	if expires > 0 && time.Now().Unix() > expires {
+		return "", ErrExpiredUrl
+	}
+
+	target, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	return string(target), nil
+}
+
+func requestChannel(r *http.Request) string {
+	return mux.Vars(r)["channelId"]
+}
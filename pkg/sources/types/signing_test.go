@@ -0,0 +1,52 @@
+package types
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	s := newURLSigner([]byte("secret"), time.Hour)
+	target := "https://example.com/live/index.m3u8?token=abc"
+	token := s.sign("channel1", target)
+
+	// signed builds a token with a valid MAC around the given fields
+	signed := func(encoded, expires string) string {
+		return encoded + "." + expires + "." + s.mac("channel1", encoded, expires)
+	}
+	parts := strings.Split(token, ".")
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 36)
+
+	tests := []struct {
+		name    string
+		channel string
+		token   string
+		err     error
+	}{
+		{"valid", "channel1", token, nil},
+		{"never expires", "channel1", newURLSigner([]byte("secret"), 0).sign("channel1", target), nil},
+		{"tampered mac", "channel1", parts[0] + "." + parts[1] + ".AAAAAAAAAAAAAAAAAAAAAA", ErrInvalidSignature},
+		{"tampered url", "channel1", signed(parts[0], parts[1])[1:], ErrInvalidSignature},
+		{"other key", "channel1", newURLSigner([]byte("other"), time.Hour).sign("channel1", target), ErrInvalidSignature},
+		{"other channel", "channel2", token, ErrInvalidSignature},
+		{"expired", "channel1", signed(parts[0], expired), ErrExpiredUrl},
+		{"malformed expiry", "channel1", signed(parts[0], "not-a-number"), ErrInvalidSignature},
+		{"malformed base64", "channel1", signed("not*base64", parts[1]), ErrInvalidSignature},
+		{"missing parts", "channel1", parts[0] + "." + parts[1], ErrInvalidSignature},
+		{"empty", "channel1", "", ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		got, err := s.verify(tt.channel, tt.token)
+		if !errors.Is(err, tt.err) || (tt.err != nil) != (err != nil) {
+			t.Errorf("%s: Unexpected error. Expected: %v, Got: %v", tt.name, tt.err, err)
+			continue
+		}
+		if err == nil && got != target {
+			t.Errorf("%s: Unexpected target. Expected: %s, Got: %s", tt.name, target, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/a13labs/m3uproxy/pkg/relay"
+	"github.com/a13labs/m3uproxy/pkg/upstream"
+)
+
+// ContinuousStreamSource serves endless HTTP streams (MPEG-TS, Icecast, ...),
+// all clients share a single upstream connection through a relay.
+type ContinuousStreamSource struct {
+	BaseStreamSource
+	relay *relay.Relay
+}
+
+func newContinuousStreamSource(base BaseStreamSource) *ContinuousStreamSource {
+	s := &ContinuousStreamSource{
+		BaseStreamSource: base,
+	}
+	s.relay = relay.NewRelay(func() (*upstream.UpstreamStream, error) {
+		return s.conn.Stream("GET", s.Url())
+	})
+	return s
+}
+
+// HealthCheck doesn't open a second upstream connection while the relay is
+// running, providers often allow a single connection per account.
+func (s *ContinuousStreamSource) HealthCheck() error {
+	if s.relay.Running() {
+		s.SetActive(true)
+		return nil
+	}
+	return s.BaseStreamSource.HealthCheck()
+}
+
+func (s *ContinuousStreamSource) MasterPlaylist() string {
+	return "stream"
+}
+
+func (s *ContinuousStreamSource) ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) error {
+	if err := s.relay.Serve(w, r); err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	return nil
+}
+
+func (s *ContinuousStreamSource) ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) error {
+	return s.ServeManifest(w, r, timeout)
+}
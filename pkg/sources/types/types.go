@@ -1,14 +1,29 @@
 package types
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
 	"github.com/a13labs/m3uproxy/pkg/upstream"
 	"github.com/elnormous/contenttype"
 )
 
+var (
+	// ErrUpstream is returned by a stream source when the upstream request
+	// failed before anything was written to the client, so the request can
+	// be retried on another source.
+	ErrUpstream = errors.New("upstream request failed")
+	// ErrInvalidRequest is returned when the request can't be mapped to an
+	// upstream URL.
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+type failoverKey struct{}
+
 type HttpDiags struct {
 	Url       string `json:"url,omitempty"`
 	Body      string `json:"body,omitempty"`
@@ -22,18 +37,24 @@ type StreamSourceDiag struct {
 	HttpProxy   string             `json:"http_proxy,omitempty"`
 	Active      bool               `json:"active,omitempty"`
 	Diagnostics []HttpDiags        `json:"diagnostics,omitempty"`
+	Cache       *SegmentCacheDiag  `json:"cache,omitempty"`
+	Health      *HealthStats       `json:"health,omitempty"`
 }
 
 type StreamSource interface {
-	ServeManifest(w http.ResponseWriter, r *http.Request, timeout int)
-	ServeMedia(w http.ResponseWriter, r *http.Request, timeout int)
+	ServeManifest(w http.ResponseWriter, r *http.Request, timeout int) error
+	ServeMedia(w http.ResponseWriter, r *http.Request, timeout int) error
 	HealthCheck() error
+	ProgressDue() (time.Time, bool)
+	CheckProgress() error
 	Diagnostic() StreamSourceDiag
 	Active() bool
+	SetActive(active bool)
 	MediaType() contenttype.MediaType
 	MediaName() string
 	MasterPlaylist() string
 	M3UTags() m3uparser.M3UTags
+	ExtInfTags() m3uparser.M3UExtinfTags
 	IsRadio() bool
 	Url() string
 }
@@ -48,6 +69,22 @@ type BaseStreamSource struct {
 	radio            bool
 	conn             *upstream.UpstreamConnection
 	disableRemap     bool
+	filter           RenditionFilter
+	health           HealthStats
+	protected        bool // DASH content protection found by the last health check
+	progress         *liveProgress
 	active           bool
 	mux              *sync.RWMutex
 }
+
+// WithFailover marks a request as being retried on a different source than
+// the one that generated the client's URL, manifests are then served from
+// the source's own playlist instead of the remapped origin URL.
+func WithFailover(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), failoverKey{}, true))
+}
+
+func isFailover(r *http.Request) bool {
+	failover, _ := r.Context().Value(failoverKey{}).(bool)
+	return failover
+}
@@ -1,6 +1,7 @@
 package streamserver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,22 +10,23 @@ import (
 
 	"github.com/a13labs/a13core/auth"
 	authproviders "github.com/a13labs/a13core/auth/providers"
+	"github.com/a13labs/a13core/logger"
 	"github.com/a13labs/m3uproxy/pkg/provider"
 	"github.com/gorilla/mux"
 )
 
 type APIHandler struct {
-	config      *ServerConfig
-	restartChan *chan bool
-	channels    *ChannelsHandler
+	config   *ServerConfig
+	channels *ChannelsHandler
+	reload   func(context.Context) error
 }
 
-func NewAPIHandler(config *ServerConfig, restartChan *chan bool, channels *ChannelsHandler) *APIHandler {
+func NewAPIHandler(config *ServerConfig, channels *ChannelsHandler, reload func(context.Context) error) *APIHandler {
 
 	return &APIHandler{
-		config:      config,
-		restartChan: restartChan,
-		channels:    channels,
+		config:   config,
+		channels: channels,
+		reload:   reload,
 	}
 }
 
@@ -260,9 +262,13 @@ func (h *APIHandler) reloadRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		w.WriteHeader(http.StatusNoContent)
-		if h.restartChan != nil {
-			*h.restartChan <- true
-		}
+		// The configuration and channels are reloaded in place, the server
+		// keeps serving
+		go func() {
+			if err := h.reload(context.Background()); err != nil {
+				logger.Errorf("Failed to reload channels: %v", err)
+			}
+		}()
 		return
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -4,14 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/a13labs/a13core/auth"
 	"github.com/a13labs/a13core/logger"
+	"github.com/a13labs/m3uproxy/pkg/cache"
 	"github.com/a13labs/m3uproxy/pkg/m3uparser"
 	"github.com/a13labs/m3uproxy/pkg/provider"
 	"github.com/a13labs/m3uproxy/pkg/sources"
+	"github.com/a13labs/m3uproxy/pkg/sources/types"
 	"github.com/gorilla/mux"
 )
 
@@ -19,26 +24,52 @@ var (
 	licenseManger *streamLicenseManager
 )
 
+// playlistFormatHLS lists DASH channels as HLS, when their content is clear.
+const playlistFormatHLS = "hls"
+
 type streamEntry struct {
 	index   int
 	tvgId   string
-	sources sources.Sources
+	sources *sources.Sources
 }
 
+// ChannelsHandler serves the channels of the channel table, which is
+// replaced as a whole on each load so requests never wait for one.
 type ChannelsHandler struct {
 	config         *ServerConfig
 	m3uCache       *m3uparser.M3UPlaylist
 	playlistConfig *provider.PlaylistConfig
-	channelsMux    sync.RWMutex
-	channels       map[string]*streamEntry
+	loadMux        sync.Mutex
+	channels       atomic.Pointer[map[string]*streamEntry]
+	health         *sources.HealthScheduler
 }
 
 func NewChannelsHandler(config *ServerConfig) *ChannelsHandler {
-	return &ChannelsHandler{
-		config:      config,
-		channels:    make(map[string]*streamEntry),
-		channelsMux: sync.RWMutex{},
+	if config.data.SegmentCacheSize > 0 {
+		types.SetSegmentCache(cache.NewSegmentCache(int64(config.data.SegmentCacheSize) * 1024 * 1024))
+	} else {
+		types.SetSegmentCache(nil)
+	}
+
+	types.SetURLSigning(config.data.Security.UrlSigningKey, time.Duration(config.data.Security.UrlExpiration)*time.Second)
+
+	health := config.data.Health
+	p := &ChannelsHandler{
+		config: config,
+		health: sources.NewHealthScheduler(sources.HealthConfig{
+			Interval:        time.Duration(health.Interval) * time.Second,
+			WatchedInterval: time.Duration(health.WatchedInterval) * time.Second,
+			RetryInterval:   time.Duration(health.RetryInterval) * time.Second,
+			HostConcurrency: health.HostConcurrency,
+			Workers:         config.data.NumWorkers,
+		}),
 	}
+	p.channels.Store(&map[string]*streamEntry{})
+	return p
+}
+
+func (p *ChannelsHandler) channelTable() map[string]*streamEntry {
+	return *p.channels.Load()
 }
 
 func (p *ChannelsHandler) RegisterRoutes(r *mux.Router) *mux.Router {
@@ -103,14 +134,12 @@ func (p *ChannelsHandler) loadConfig() error {
 
 func (p *ChannelsHandler) getActiveChannels() []*streamEntry {
 	// get a list of all active streams
-	p.channelsMux.RLock()
 	activeChannels := make([]*streamEntry, 0)
-	for _, channel := range p.channels {
+	for _, channel := range p.channelTable() {
 		if channel.sources.Active() {
 			activeChannels = append(activeChannels, channel)
 		}
 	}
-	p.channelsMux.RUnlock()
 	// Sort channels by index
 	for i := 0; i < len(activeChannels); i++ {
 		for j := i + 1; j < len(activeChannels); j++ {
@@ -122,82 +151,107 @@ func (p *ChannelsHandler) getActiveChannels() []*streamEntry {
 	return activeChannels
 }
 
+// Load refreshes the provider playlists and applies their changes to the
+// channels: channels and sources that left the playlists are removed, new
+// ones added and the unchanged ones kept, so their viewers aren't cut off.
+// The new channel table replaces the current one at once.
 func (p *ChannelsHandler) Load(ctx context.Context) error {
 
+	p.loadMux.Lock()
+	defer p.loadMux.Unlock()
+
 	if err := p.loadConfig(); err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	streamsChan := make(chan *streamEntry)
-	stopWorkers := make(chan bool)
-
-	for i := 0; i < p.config.data.NumWorkers; i++ {
-		wg.Add(1)
-		go monitorWorker(streamsChan, stopWorkers, &wg)
+	type playlistChannel struct {
+		index   int
+		entries []m3uparser.M3UEntry
 	}
 
-	go func() {
-		for i, entry := range p.m3uCache.Entries {
-			select {
-			case <-ctx.Done():
-				stopWorkers <- true
-				wg.Wait()
-				return
-			default:
-				if entry.URI == "" {
-					continue
-				}
+	// Group the playlist entries by channel, in the playlist order
+	playlistChannels := make(map[string]*playlistChannel)
+	var order []string
+	for i, entry := range p.m3uCache.Entries {
+		if entry.URI == "" {
+			continue
+		}
 
-				tvgId := entry.ExtInfTags.GetValue("tvg-id")
-				if tvgId == "" {
-					tvgId = entry.Title
-				}
+		tvgId := entry.ExtInfTags.GetValue("tvg-id")
+		if tvgId == "" {
+			tvgId = entry.Title
+		}
 
-				radio := entry.ExtInfTags.GetValue("radio")
-				if tvgId == "" && radio == "" {
-					logger.Warnf("No tvg-id or radio tag found for %s, skipping", entry.URI)
-					continue
-				}
+		radio := entry.ExtInfTags.GetValue("radio")
+		if tvgId == "" && radio == "" {
+			logger.Warnf("No tvg-id or radio tag found for %s, skipping", entry.URI)
+			continue
+		}
 
-				channel, ok := p.channels[tvgId]
-				if !ok {
-					p.channelsMux.Lock()
-					p.channels[tvgId] = &streamEntry{
-						index:   i,
-						tvgId:   tvgId,
-						sources: sources.NewSources(),
-					}
-					channel = p.channels[tvgId]
-					p.channelsMux.Unlock()
-				}
+		channel, ok := playlistChannels[tvgId]
+		if !ok {
+			channel = &playlistChannel{index: i}
+			playlistChannels[tvgId] = channel
+			order = append(order, tvgId)
+		}
+		channel.entries = append(channel.entries, entry)
+	}
 
-				if channel.sources.SourceExists(entry) {
-					logger.Warnf("Stream source already exists: %s", entry.URI)
-					continue
-				}
+	// Syncing applies the changes to the channels in use, once started the
+	// new table must be stored
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-				logger.Infof("Adding stream source for %s, for channel %s", entry.URI, tvgId)
-				added, err := channel.sources.AddSource(entry, p.config.data.Timeout)
-				if err != nil {
-					logger.Errorf("Error adding stream source for %s: %v", entry.URI, err)
-					continue
-				}
-				if !added {
-					logger.Warnf("Stream source already exists: %s", entry.URI)
-					continue
-				}
-				streamsChan <- channel
-			}
+	current := p.channelTable()
+	channels := make(map[string]*streamEntry, len(order))
+	for _, tvgId := range order {
+		channel := &streamEntry{
+			index: playlistChannels[tvgId].index,
+			tvgId: tvgId,
 		}
-		close(streamsChan)
-	}()
+		if existing, ok := current[tvgId]; ok {
+			channel.sources = existing.sources
+		} else {
+			logger.Infof("Adding channel %s", tvgId)
+			channelSources := sources.NewSources()
+			channel.sources = &channelSources
+		}
+
+		added, removed := channel.sources.Sync(playlistChannels[tvgId].entries, p.config.data.Timeout)
+		for _, source := range removed {
+			logger.Infof("Removing stream source %s, for channel %s", source.Url(), tvgId)
+			p.health.Remove(source)
+		}
+		for _, source := range added {
+			logger.Infof("Adding stream source %s, for channel %s", source.Url(), tvgId)
+			p.health.Add(channel.sources, source)
+		}
+		channels[tvgId] = channel
+	}
 
-	wg.Wait()
+	p.channels.Store(&channels)
+
+	for tvgId, channel := range current {
+		if _, ok := channels[tvgId]; ok {
+			continue
+		}
+		logger.Infof("Removing channel %s", tvgId)
+		_, removed := channel.sources.Sync(nil, p.config.data.Timeout)
+		for _, source := range removed {
+			p.health.Remove(source)
+		}
+	}
 
 	return nil
 }
 
+// RunHealthChecks checks the sources of the channels in the background until
+// the context is done.
+func (p *ChannelsHandler) RunHealthChecks(ctx context.Context) {
+	p.health.Run(ctx)
+}
+
 func (p *ChannelsHandler) playlistRequest(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
@@ -217,6 +271,15 @@ func (p *ChannelsHandler) playlistRequest(w http.ResponseWriter, r *http.Request
 		scheme = "http"
 	}
 
+	// The format can be chosen through the playlist URL, otherwise the user's
+	// preference is used
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		if user, err := auth.GetUserFromToken(token); err == nil {
+			format = p.config.GetUserSettings(user).PlaylistFormat
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("#EXTM3U\n"))
@@ -232,18 +295,33 @@ func (p *ChannelsHandler) playlistRequest(w http.ResponseWriter, r *http.Request
 			continue
 		}
 
+		playlist := channel.sources.MasterPlaylist()
+		if format == playlistFormatHLS {
+			if hls := channel.sources.HLSPlaylist(); hls != "" {
+				playlist = hls
+			}
+		}
+
 		tvgId := strings.ReplaceAll(channel.tvgId, " ", "%20")
-		uri := fmt.Sprintf("%s://%s/%s/%s/%s", scheme, r.Host, token, tvgId, channel.sources.MasterPlaylist())
+		uri := fmt.Sprintf("%s://%s/%s/%s/%s", scheme, r.Host, token, tvgId, playlist)
 
 		entry := m3uparser.M3UEntry{
-			URI:   uri,
-			Title: channel.sources.MediaName(),
-			Tags:  make([]m3uparser.M3UTag, 0),
+			URI:        uri,
+			Duration:   -1,
+			Title:      channel.sources.MediaName(),
+			Tags:       make([]m3uparser.M3UTag, 0),
+			ExtInfTags: channel.sources.ExtInfTags(),
 		}
 		entry.Tags = append(entry.Tags, channel.sources.M3UTags()...)
 		if !channel.sources.IsRadio() {
-			entry.AddTag("KODIPROP", "inputstream=inputstream.adaptive")
-			entry.AddTag("KODIPROP", "inputstream.adaptive.manifest_type=hls")
+			switch path.Ext(playlist) {
+			case ".mpd":
+				entry.AddTag("KODIPROP", "inputstream=inputstream.adaptive")
+				entry.AddTag("KODIPROP", "inputstream.adaptive.manifest_type=mpd")
+			case ".m3u8":
+				entry.AddTag("KODIPROP", "inputstream=inputstream.adaptive")
+				entry.AddTag("KODIPROP", "inputstream.adaptive.manifest_type=hls")
+			}
 		}
 		w.Write([]byte(entry.String() + "\n"))
 	}
@@ -272,10 +350,7 @@ func (p *ChannelsHandler) manifestRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	p.channelsMux.RLock()
-	defer p.channelsMux.RUnlock()
-
-	channel, ok := p.channels[channelId]
+	channel, ok := p.channelTable()[channelId]
 	if !ok {
 		http.Error(w, "Stream not found", http.StatusNotFound)
 		return
@@ -286,6 +361,10 @@ func (p *ChannelsHandler) manifestRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if user, err := auth.GetUserFromToken(token); err == nil {
+		r = types.WithRenditionFilter(r, p.config.GetUserSettings(user).RenditionFilter)
+	}
+
 	channel.sources.ServeManifest(w, r, p.config.data.Timeout)
 }
 
@@ -312,10 +391,7 @@ func (p *ChannelsHandler) mediaRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p.channelsMux.RLock()
-	defer p.channelsMux.RUnlock()
-
-	channel, ok := p.channels[channelId]
+	channel, ok := p.channelTable()[channelId]
 	if !ok {
 		http.Error(w, "Stream not found", http.StatusNotFound)
 		return
@@ -330,26 +406,5 @@ func (p *ChannelsHandler) mediaRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *ChannelsHandler) GetChannel(id string) *streamEntry {
-	p.channelsMux.RLock()
-	defer p.channelsMux.RUnlock()
-	return p.channels[id]
-}
-
-func monitorWorker(streams <-chan *streamEntry, stop <-chan bool, wg *sync.WaitGroup) {
-
-	defer wg.Done()
-	for stream := range streams {
-		select {
-		case <-stop:
-			return
-		default:
-			stream.sources.HealthCheck()
-			if stream.sources.GetActiveSource() == nil {
-				continue
-			}
-			if !stream.sources.Active() {
-				logger.Warnf("Stream %s is not active", stream.sources.GetActiveSource().MediaName())
-			}
-		}
-	}
+	return p.channelTable()[id]
 }
@@ -3,6 +3,8 @@ package streamserver
 import (
 	"encoding/json"
 	"os"
+
+	"github.com/a13labs/m3uproxy/pkg/sources/types"
 )
 
 type GeoIPConfig struct {
@@ -14,18 +16,40 @@ type GeoIPConfig struct {
 type SecurityConfig struct {
 	GeoIP              GeoIPConfig `json:"geoip,omitempty"`
 	AllowedCORSDomains []string    `json:"allowed_cors_domains,omitempty"`
+	UrlSigningKey      string      `json:"url_signing_key,omitempty"`
+	UrlExpiration      int         `json:"url_expiration,omitempty"` // seconds, 0 never expires
+}
+
+// HealthConfig sets how often stream sources are checked, in seconds, zero
+// values use the defaults.
+type HealthConfig struct {
+	Interval        int `json:"interval,omitempty"`         // healthy sources
+	WatchedInterval int `json:"watched_interval,omitempty"` // sources of channels being watched
+	RetryInterval   int `json:"retry_interval,omitempty"`   // first retry of failing sources
+	HostConcurrency int `json:"host_concurrency,omitempty"` // checks running at once on a host
+}
+
+// UserSettings are the preferences of a user, PlaylistFormat "hls" lists DASH
+// channels with clear content as HLS. The rendition filter applies to all
+// the channels the user plays.
+type UserSettings struct {
+	PlaylistFormat string `json:"playlist_format,omitempty"`
+	types.RenditionFilter
 }
 
 type ConfigData struct {
-	Port       int             `json:"port"`
-	Playlist   string          `json:"playlist"`
-	Epg        string          `json:"epg"`
-	Timeout    int             `json:"default_timeout,omitempty"`
-	NumWorkers int             `json:"num_workers,omitempty"`
-	ScanTime   int             `json:"scan_time,omitempty"`
-	Security   SecurityConfig  `json:"security,omitempty"`
-	Auth       json.RawMessage `json:"auth"`
-	LogFile    string          `json:"log_file,omitempty"`
+	Port             int                     `json:"port"`
+	Playlist         string                  `json:"playlist"`
+	Epg              string                  `json:"epg"`
+	Timeout          int                     `json:"default_timeout,omitempty"`
+	NumWorkers       int                     `json:"num_workers,omitempty"`
+	ScanTime         int                     `json:"scan_time,omitempty"`
+	Health           HealthConfig            `json:"health,omitempty"`
+	Security         SecurityConfig          `json:"security,omitempty"`
+	Auth             json.RawMessage         `json:"auth"`
+	LogFile          string                  `json:"log_file,omitempty"`
+	SegmentCacheSize int                     `json:"segment_cache_size,omitempty"` // MB, 0 disables the cache
+	Users            map[string]UserSettings `json:"users,omitempty"`
 }
 
 type ServerConfig struct {
@@ -47,6 +71,12 @@ func NewServerConfig(path string) *ServerConfig {
 				Timeout:    5,
 				NumWorkers: 4,
 				ScanTime:   60,
+				Health: HealthConfig{
+					Interval:        300,
+					WatchedInterval: 30,
+					RetryInterval:   10,
+					HostConcurrency: 2,
+				},
 				Security: SecurityConfig{
 					GeoIP: GeoIPConfig{
 						Database:         "GeoLite2-Country.mmdb",
@@ -55,8 +85,9 @@ func NewServerConfig(path string) *ServerConfig {
 					},
 					AllowedCORSDomains: []string{},
 				},
-				Auth:    json.RawMessage("{}"),
-				LogFile: "server.log",
+				Auth:             json.RawMessage("{}"),
+				LogFile:          "server.log",
+				SegmentCacheSize: 256,
 			}
 			if err := c.Save(); err != nil {
 				panic(err)
@@ -78,6 +109,12 @@ func (c *ConfigData) Merge(other ConfigData) {
 	if other.ScanTime != 0 {
 		c.ScanTime = other.ScanTime
 	}
+	if other.Health != (HealthConfig{}) {
+		c.Health = other.Health
+	}
+	if other.SegmentCacheSize != 0 {
+		c.SegmentCacheSize = other.SegmentCacheSize
+	}
 	if len(other.Security.GeoIP.Whitelist) > 0 {
 		c.Security.GeoIP.Whitelist = other.Security.GeoIP.Whitelist
 	}
@@ -167,6 +204,14 @@ func (c *ServerConfig) SetScanTime(scanTime int) {
 	c.data.ScanTime = scanTime
 }
 
+func (c *ServerConfig) GetSegmentCacheSize() int {
+	return c.data.SegmentCacheSize
+}
+
+func (c *ServerConfig) SetSegmentCacheSize(size int) {
+	c.data.SegmentCacheSize = size
+}
+
 func (c *ServerConfig) GetSecurity() SecurityConfig {
 	return c.data.Security
 }
@@ -175,6 +220,17 @@ func (c *ServerConfig) SetSecurity(security SecurityConfig) {
 	c.data.Security = security
 }
 
+func (c *ServerConfig) GetUserSettings(username string) UserSettings {
+	return c.data.Users[username]
+}
+
+func (c *ServerConfig) SetUserSettings(username string, settings UserSettings) {
+	if c.data.Users == nil {
+		c.data.Users = make(map[string]UserSettings)
+	}
+	c.data.Users[username] = settings
+}
+
 func (c *ServerConfig) GetAuth() json.RawMessage {
 	return c.data.Auth
 }
@@ -1,13 +1,16 @@
 package streamserver
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,8 +29,10 @@ type StreamServer struct {
 	epg                *EPGHandler
 	channels           *ChannelsHandler
 	player             *PlayerHandler
-	restartChan        chan bool
 	router             *mux.Router
+	reloadMux          sync.Mutex
+	auth               json.RawMessage // authentication settings in effect
+	securityMux        sync.RWMutex
 	geoipDb            *geoip2.Reader
 	geoipWhitelist     map[string]bool
 	geoIPCidrWhitelist []*net.IPNet
@@ -36,9 +41,8 @@ type StreamServer struct {
 // Initialize the server
 func NewStreamServer(configPath string) *StreamServer {
 	s := StreamServer{
-		restartChan: make(chan bool),
-		config:      NewServerConfig(configPath),
-		router:      mux.NewRouter(),
+		config: NewServerConfig(configPath),
+		router: mux.NewRouter(),
 	}
 
 	return &s
@@ -49,7 +53,7 @@ func (s *StreamServer) Run() {
 	logger.Init(s.config.data.LogFile)
 
 	s.channels = NewChannelsHandler(s.config)
-	s.api = NewAPIHandler(s.config, &s.restartChan, s.channels)
+	s.api = NewAPIHandler(s.config, s.channels, s.reload)
 	s.api.RegisterRoutes(s.router)
 	s.channels.RegisterRoutes(s.router)
 
@@ -61,88 +65,73 @@ func (s *StreamServer) Run() {
 
 	s.router.HandleFunc("/health", s.healthCheckRequest)
 
-	for {
-		logger.Infof("Starting M3U Proxy Server")
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	go s.channels.RunHealthChecks(healthCtx)
 
-		logger.Infof("Starting stream server")
-		logger.Infof("Playlist: %s", s.config.data.Playlist)
-		logger.Infof("EPG: %s", s.config.data.Epg)
+	logger.Infof("Starting M3U Proxy Server")
 
-		err := auth.InitializeAuth(s.config.data.Auth)
-		if err != nil {
-			logger.Errorf("Failed to initialize authentication: %s", err)
-			return
-		}
+	logger.Infof("Starting stream server")
+	logger.Infof("Playlist: %s", s.config.data.Playlist)
+	logger.Infof("EPG: %s", s.config.data.Epg)
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	err := s.initializeAuth()
+	if err != nil {
+		logger.Errorf("Failed to initialize authentication: %s", err)
+		return
+	}
 
-		s.updateTimer = time.NewTimer(time.Duration(s.config.data.ScanTime) * time.Second)
-		s.running = true
-		go func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.updateTimer = time.NewTimer(time.Duration(s.config.data.ScanTime) * time.Second)
+	s.running = true
+	go func() {
+		s.channels.Load(ctx)
+		for {
+			<-s.updateTimer.C
 			s.channels.Load(ctx)
-			for {
-				<-s.updateTimer.C
-				s.channels.Load(ctx)
-				if s.running {
-					s.updateTimer.Reset(time.Duration(s.config.data.ScanTime) * time.Second)
-				}
+			if s.running {
+				s.updateTimer.Reset(time.Duration(s.config.data.ScanTime) * time.Second)
 			}
-		}()
-
-		if s.configureSecurity() != nil {
-			logger.Warn("GeoIP database not found, geo-location will not be available.")
 		}
+	}()
 
-		server := &http.Server{
-			Addr:    fmt.Sprintf(":%d", s.config.data.Port),
-			Handler: s.secure(s.router),
-		}
+	if err := s.configureSecurity(); err != nil {
+		logger.Warnf("GeoIP not configured, geo-location may not be available: %v", err)
+	}
 
-		// Channel to listen for termination signal (SIGINT, SIGTERM)
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.config.data.Port),
+		Handler: s.secure(s.router),
+	}
 
-		go func() {
-			logger.Infof("Server listening on %s.", server.Addr)
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Errorf("Server failed: %v", err)
-			}
-		}()
-
-		quitServer := false
-		select {
-		case <-sigChan:
-			logger.Info("Signal received, shutting down server...")
-			quitServer = true
-			cancel()
-		case <-s.restartChan:
-			quitServer = false
+	// Channel to listen for termination signal (SIGINT, SIGTERM)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		logger.Infof("Server listening on %s.", server.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("Server failed: %v", err)
 		}
+	}()
 
-		s.updateTimer.Stop()
-		s.running = false
-		logger.Info("Stream server stopped")
+	<-sigChan
+	logger.Info("Signal received, shutting down server...")
+	cancel()
 
-		s.cleanGeoIp()
+	s.updateTimer.Stop()
+	s.running = false
+	logger.Info("Stream server stopped")
 
-		if err := server.Shutdown(ctx); err != nil {
-			logger.Errorf("Server forced to shutdown: %v", err)
-		}
+	s.cleanGeoIp()
 
-		if quitServer {
-			logger.Info("Server shutdown.")
-			break
-		}
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Errorf("Server forced to shutdown: %v", err)
 	}
-}
 
-func (s *StreamServer) Restart() {
-	go func() {
-		logger.Info("Server restart in 3 seconds")
-		time.Sleep(3 * time.Second)
-		s.restartChan <- true
-	}()
+	logger.Info("Server shutdown.")
 }
 
 func (s *StreamServer) healthCheckRequest(w http.ResponseWriter, r *http.Request) {
@@ -162,107 +151,169 @@ func (s *StreamServer) healthCheckRequest(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+// initializeAuth applies the authentication settings of the configuration,
+// unless they are already in effect.
+func (s *StreamServer) initializeAuth() error {
+	if s.auth != nil && bytes.Equal(s.auth, s.config.data.Auth) {
+		return nil
+	}
+	if err := auth.InitializeAuth(s.config.data.Auth); err != nil {
+		return err
+	}
+	s.auth = bytes.Clone(s.config.data.Auth)
+	return nil
+}
+
+// reload applies the configuration again, as it is after changes through the
+// API, and reloads the channels. The port, the log file and the URL signing
+// settings are only read at startup.
+func (s *StreamServer) reload(ctx context.Context) error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+
+	if err := s.initializeAuth(); err != nil {
+		logger.Errorf("Failed to reload authentication, keeping the previous settings: %s", err)
+	}
+	if err := s.configureSecurity(); err != nil {
+		logger.Warnf("GeoIP not configured, geo-location may not be available: %v", err)
+	}
+	return s.channels.Load(ctx)
+}
+
+// configureSecurity opens the GeoIP database and reads the whitelists, they
+// replace the ones in effect.
 func (s *StreamServer) configureSecurity() error {
 
 	var err error
+	var geoipDb *geoip2.Reader
+	geoipWhitelist := make(map[string]bool)
+	geoIPCidrWhitelist := make([]*net.IPNet, 0)
 
-	if s.config.data.Security.GeoIP.Database == "" {
-		return nil
+	if len(s.config.data.Security.AllowedCORSDomains) > 0 {
+		logger.Info("CORS enabled")
 	}
 
-	s.geoipDb, err = geoip2.Open(s.config.data.Security.GeoIP.Database)
-	if err != nil {
-		s.geoipDb = nil
-		return err
+	if s.config.data.Security.GeoIP.Database != "" {
+		geoipDb, err = geoip2.Open(s.config.data.Security.GeoIP.Database)
+		if err != nil {
+			geoipDb = nil
+		}
 	}
 
-	s.geoipWhitelist = make(map[string]bool)
-	for _, country := range s.config.data.Security.GeoIP.Whitelist {
-		s.geoipWhitelist[country] = true
-	}
+	if geoipDb != nil {
+		logger.Info("GeoIP enabled")
 
-	s.geoIPCidrWhitelist = make([]*net.IPNet, 0)
+		for _, country := range s.config.data.Security.GeoIP.Whitelist {
+			geoipWhitelist[country] = true
+		}
 
-	for _, cidr := range s.config.data.Security.GeoIP.InternalNetworks {
-		_, ipnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			return err
+		for _, cidr := range s.config.data.Security.GeoIP.InternalNetworks {
+			_, ipnet, cidrErr := net.ParseCIDR(cidr)
+			if cidrErr != nil {
+				err = cidrErr
+				break
+			}
+			geoIPCidrWhitelist = append(geoIPCidrWhitelist, ipnet)
 		}
-		s.geoIPCidrWhitelist = append(s.geoIPCidrWhitelist, ipnet)
 	}
 
-	return nil
+	s.securityMux.Lock()
+	previous := s.geoipDb
+	s.geoipDb = geoipDb
+	s.geoipWhitelist = geoipWhitelist
+	s.geoIPCidrWhitelist = geoIPCidrWhitelist
+	s.securityMux.Unlock()
+
+	// Lookups hold the read lock, none is using the previous database
+	if previous != nil {
+		previous.Close()
+	}
+
+	return err
 }
 
 func (s *StreamServer) cleanGeoIp() {
+	s.securityMux.Lock()
+	defer s.securityMux.Unlock()
 	if s.geoipDb != nil {
 		s.geoipDb.Close()
+		s.geoipDb = nil
 	}
 }
 
-func (s *StreamServer) secure(next http.Handler) http.Handler {
+// geoIPStatus returns the status denying the request if the client isn't in a
+// whitelisted country or internal network, 0 if it is allowed.
+func (s *StreamServer) geoIPStatus(r *http.Request) int {
+	s.securityMux.RLock()
+	defer s.securityMux.RUnlock()
 
-	if len(s.config.data.Security.AllowedCORSDomains) > 0 {
-		next = s.cors(next)
+	if s.geoipDb == nil {
+		return 0
 	}
 
-	if s.geoipDb == nil {
-		return next
+	ip := ""
+	if r.Header.Get("X-Real-IP") != "" {
+		ip = r.Header.Get("X-Real-IP")
+	} else if r.Header.Get("X-Forwarded-For") != "" {
+		ips := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		ip = ips[0]
+	} else {
+		var err error
+		ip, _, err = net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return http.StatusInternalServerError
+		}
 	}
 
-	logger.Info("GeoIP enabled")
+	if ip == "" {
+		return http.StatusInternalServerError
+	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	parsedIP := net.ParseIP(ip)
 
-		ip := ""
-		if r.Header.Get("X-Real-IP") != "" {
-			ip = r.Header.Get("X-Real-IP")
-		} else if r.Header.Get("X-Forwarded-For") != "" {
-			ips := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
-			ip = ips[0]
-		} else {
-			var err error
-			ip, _, err = net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
+	for _, ipnet := range s.geoIPCidrWhitelist {
+		if ipnet.Contains(parsedIP) {
+			return 0
 		}
+	}
 
-		if ip == "" {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	record, err := s.geoipDb.Country(parsedIP)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
 
-		parsedIP := net.ParseIP(ip)
+	countryCode := record.Country.IsoCode
+	if _, ok := s.geoipWhitelist[countryCode]; !ok {
+		logger.Infof("Access Denied: %s, Country: %s", ip, countryCode)
+		return http.StatusForbidden
+	}
+	return 0
+}
 
-		for _, ipnet := range s.geoIPCidrWhitelist {
-			if ipnet.Contains(parsedIP) {
-				next.ServeHTTP(w, r)
-				return
-			}
-		}
+// secure applies the security settings in effect to each request, they can
+// change on reload.
+func (s *StreamServer) secure(next http.Handler) http.Handler {
 
-		record, err := s.geoipDb.Country(parsedIP)
-		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	next = s.cors(next)
 
-		countryCode := record.Country.IsoCode
-		if _, ok := s.geoipWhitelist[countryCode]; !ok {
-			logger.Infof("Access Denied: %s, Country: %s", ip, countryCode)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch s.geoIPStatus(r) {
+		case 0:
+			next.ServeHTTP(w, r)
+		case http.StatusForbidden:
 			http.Error(w, "Access Denied", http.StatusForbidden)
-			return
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
-
-		next.ServeHTTP(w, r)
 	})
 }
 
 func (s *StreamServer) cors(next http.Handler) http.Handler {
-	logger.Info("CORS enabled")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.data.Security.AllowedCORSDomains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		w.Header().Set("Access-Control-Allow-Origin", strings.Join(s.config.data.Security.AllowedCORSDomains, ","))
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PUT, DELETE")
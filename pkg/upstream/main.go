@@ -31,6 +31,11 @@ func NewUpstreamConnection(headers map[string]string, proxy string, timeout int)
 			ReadTimeout: time.Duration(timeout) * time.Second,
 			Dial:        dial,
 		},
+		// ReadTimeout would bound the whole body, streams use an idle timeout instead
+		streamClient: &fasthttp.Client{
+			Dial:               idleTimeoutDial(dial, time.Duration(timeout)*time.Second),
+			StreamResponseBody: true,
+		},
 		headers: headers,
 	}
 }
@@ -38,7 +43,6 @@ func NewUpstreamConnection(headers map[string]string, proxy string, timeout int)
 func (u *UpstreamConnection) Check(method string, uri string) (string, contenttype.MediaType, error) {
 	const maxRedirects = 10
 	currentURL := uri
-	var resp *fasthttp.Response
 
 	for i := 0; i < maxRedirects; i++ {
 		req := fasthttp.AcquireRequest()
@@ -51,8 +55,9 @@ func (u *UpstreamConnection) Check(method string, uri string) (string, contentty
 			req.Header.Set(key, value)
 		}
 
-		resp = fasthttp.AcquireResponse()
-		err := u.client.Do(req, resp)
+		// Only the headers are needed, the body of continuous streams never ends
+		resp := fasthttp.AcquireResponse()
+		err := u.streamClient.Do(req, resp)
 		if err != nil {
 			fasthttp.ReleaseResponse(resp)
 			return "", contenttype.MediaType{}, err
@@ -64,7 +69,7 @@ func (u *UpstreamConnection) Check(method string, uri string) (string, contentty
 		if statusCode/100 == 3 { // Handle redirects (3xx status codes)
 			location := resp.Header.Peek("Location")
 			if location == nil {
-				fasthttp.ReleaseResponse(resp)
+				releaseStreamResponse(resp)
 				return "", ct, fmt.Errorf("redirect response missing Location header")
 			}
 
@@ -73,12 +78,12 @@ func (u *UpstreamConnection) Check(method string, uri string) (string, contentty
 			if !strings.HasPrefix(newURL, "http") {
 				baseURL, err := url.Parse(currentURL)
 				if err != nil {
-					fasthttp.ReleaseResponse(resp)
+					releaseStreamResponse(resp)
 					return "", ct, fmt.Errorf("failed to parse base URL: %w", err)
 				}
 				relativeURL, err := url.Parse(newURL)
 				if err != nil {
-					fasthttp.ReleaseResponse(resp)
+					releaseStreamResponse(resp)
 					return "", ct, fmt.Errorf("failed to parse relative URL: %w", err)
 				}
 				currentURL = baseURL.ResolveReference(relativeURL).String()
@@ -87,17 +92,15 @@ func (u *UpstreamConnection) Check(method string, uri string) (string, contentty
 			}
 
 			// Release the response and continue to the next redirect
-			fasthttp.ReleaseResponse(resp)
+			releaseStreamResponse(resp)
 			continue
 		}
 
+		releaseStreamResponse(resp)
 		return currentURL, ct, nil
 	}
 
 	// Exceeded maximum redirects
-	if resp != nil {
-		fasthttp.ReleaseResponse(resp)
-	}
 	return "", contenttype.MediaType{}, fmt.Errorf("too many redirects")
 }
 
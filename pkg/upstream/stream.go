@@ -0,0 +1,157 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elnormous/contenttype"
+	"github.com/valyala/fasthttp"
+)
+
+const relayBufferSize = 32 * 1024
+
+var relayBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, relayBufferSize)
+		return &b
+	},
+}
+
+// idleTimeoutConn fails a read when no data arrived for the given timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func idleTimeoutDial(dial fasthttp.DialFunc, timeout time.Duration) fasthttp.DialFunc {
+	if timeout <= 0 {
+		return dial
+	}
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// Stream sends the request and returns as soon as the response headers are
+// received, the body is then read from the returned stream.
+func (u *UpstreamConnection) Stream(method, URI string) (*UpstreamStream, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(URI)
+	req.Header.SetMethod(method)
+
+	for key, value := range u.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	err := u.streamClient.Do(req, resp)
+	if err != nil {
+		fasthttp.ReleaseResponse(resp)
+		return nil, err
+	}
+
+	statusCode := resp.StatusCode()
+	ct := contenttype.NewMediaType(string(resp.Header.ContentType()))
+	if statusCode/100 != 2 {
+		releaseStreamResponse(resp)
+		return nil, fmt.Errorf("http response code (%d)", statusCode)
+	}
+
+	if statusCode == fasthttp.StatusNoContent {
+		releaseStreamResponse(resp)
+		return nil, errors.New("no content")
+	}
+
+	return &UpstreamStream{
+		resp:          resp,
+		StatusCode:    statusCode,
+		MediaType:     ct,
+		ContentLength: resp.Header.ContentLength(),
+	}, nil
+}
+
+// releaseStreamResponse releases a streamed response whose body wasn't read.
+func releaseStreamResponse(resp *fasthttp.Response) {
+	resp.Header.SetConnectionClose()
+	fasthttp.ReleaseResponse(resp)
+}
+
+func (s *UpstreamStream) body() io.Reader {
+	if stream := s.resp.BodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(s.resp.Body())
+}
+
+func (s *UpstreamStream) Read(p []byte) (int, error) {
+	n, err := s.body().Read(p)
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+func (s *UpstreamStream) Close() error {
+	// A partially read body leaves the connection unusable for another request
+	if !s.eof {
+		s.resp.Header.SetConnectionClose()
+	}
+	err := s.resp.CloseBodyStream()
+	fasthttp.ReleaseResponse(s.resp)
+	return err
+}
+
+// Relay copies the body to w using a bounded buffer, flushing after every
+// chunk. It stops when the context is done or the client goes away.
+func (s *UpstreamStream) Relay(ctx context.Context, w io.Writer) (int64, error) {
+	bufp := relayBufferPool.Get().(*[]byte)
+	defer relayBufferPool.Put(bufp)
+	buf := *bufp
+
+	flusher, _ := w.(http.Flusher)
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		n, rerr := s.Read(buf)
+		if n > 0 {
+			nw, werr := w.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
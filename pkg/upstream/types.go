@@ -1,10 +1,22 @@
 package upstream
 
 import (
+	"github.com/elnormous/contenttype"
 	"github.com/valyala/fasthttp"
 )
 
 type UpstreamConnection struct {
-	client  *fasthttp.Client
-	headers map[string]string
+	client       *fasthttp.Client
+	streamClient *fasthttp.Client
+	headers      map[string]string
+}
+
+// UpstreamStream is an upstream response whose body is read as it arrives
+// instead of being buffered, it must be closed after use.
+type UpstreamStream struct {
+	resp          *fasthttp.Response
+	StatusCode    int
+	MediaType     contenttype.MediaType
+	ContentLength int // -1 when unknown
+	eof           bool
 }